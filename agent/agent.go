@@ -0,0 +1,120 @@
+// Package agent runs the headless printer agent: it loads the settings,
+// opens the job queue, serves the HTTP API and applies edits to
+// printer-config.json until it is stopped. The root, linux and
+// windows-legacy binaries only differ in the defaults they pass to Run.
+package agent
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"cleanlink/printer/certs"
+	"cleanlink/printer/config"
+	"cleanlink/printer/discovery"
+	"cleanlink/printer/queue"
+	"cleanlink/printer/server"
+)
+
+// Run starts the agent with defaults overridden by printer-config.json,
+// the environment and os.Args, and serves until Ctrl+C or a service stop.
+// It exits the process if the agent cannot start.
+func Run(defaults config.Config) {
+	// "new-key <name>" and "revoke-key <name>" manage API keys and exit
+	if handled, err := config.KeyCommand(os.Args[1:], os.Stdout); handled {
+		if err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	store, err := config.NewStore(defaults, os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		fmt.Println("Config error:", err)
+		os.Exit(1)
+	}
+
+	cfg := store.Get()
+	if cfg.ActiveKeys() == 0 && !cfg.LegacyBodyToken {
+		fmt.Println("[AUTH] No API keys configured, print requests will be rejected. Create one with: new-key <name>")
+	}
+	opts, err := serverOptions(cfg)
+	if err != nil {
+		fmt.Println("Invalid printer configuration:", err)
+		os.Exit(1)
+	}
+
+	jobs, err := queue.Open(queue.Options{Dir: cfg.QueueDir})
+	if err != nil {
+		fmt.Println("Queue error:", err)
+		os.Exit(1)
+	}
+
+	// Ctrl+C or a service stop finishes the current job before exiting
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	srv := server.New(opts, jobs)
+	srv.Start(ctx)
+
+	// Apply edits to printer-config.json without a restart
+	go func() {
+		err := store.Watch(ctx, func(cfg *config.Config) error {
+			opts, err := serverOptions(cfg)
+			if err != nil {
+				return fmt.Errorf("invalid printer configuration: %w", err)
+			}
+			srv.SetOptions(opts)
+			return nil
+		})
+		if err != nil {
+			fmt.Println("[CONFIG] Hot reload disabled:", err)
+		}
+	}()
+
+	listeners := []server.Listener{{Addr: cfg.Listen}}
+	if cfg.HTTPSListen != "" {
+		bundle, err := certs.LoadOrCreate(cfg.CertDir)
+		if err != nil {
+			fmt.Println("HTTPS error:", err)
+			os.Exit(1)
+		}
+		srv.SetCACert(bundle.CAPEM)
+		listeners = append(listeners, server.Listener{Addr: cfg.HTTPSListen, TLS: bundle.TLSConfig()})
+		fmt.Println("Cleanlink Printer Agent running on", cfg.HTTPSListen, "(HTTPS, install the CA from "+cfg.LocalHTTPSURL()+"/cert)")
+	}
+
+	fmt.Println("Cleanlink Printer Agent running on", cfg.Listen)
+	if err := srv.ListenAndServe(ctx, listeners...); err != nil {
+		fmt.Println("Server error:", err)
+		os.Exit(1)
+	}
+
+	fmt.Println("[SHUTDOWN] Stopping, waiting for the current print job")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	srv.Shutdown(shutdownCtx)
+}
+
+// serverOptions returns the server settings for cfg. Without a configured
+// device the printer whose name contains device_id, or else the likeliest
+// printer, is looked up; a printer saved by fingerprint is looked up on its
+// current port. Either is looked up at startup and again when it stops
+// answering, see discovery.Printer.
+func serverOptions(cfg *config.Config) (server.Options, error) {
+	printer, err := discovery.Printer(cfg.Printer, cfg.DeviceID)
+	if err != nil {
+		return server.Options{}, err
+	}
+
+	return server.OptionsFrom(cfg, printer), nil
+}
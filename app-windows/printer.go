@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"cleanlink/printer/config"
+	"cleanlink/printer/discovery"
+	"cleanlink/printer/server"
+	"cleanlink/printer/transport"
+)
+
+// Global variable to store selected printer COM port
+var selectedPrinterCOM string
+
+// Agent settings loaded from printer-config.json, env and flags
+var settings *config.Store
+
+// ================= CORE =================
+
+// printerAt returns the function that gives the server the printer on
+// device, the COM port picked by the user. A printer saved by fingerprint
+// is looked up on its current port when it stops answering, so it keeps
+// printing after Windows renumbered its COM port, see discovery.Printer.
+func printerAt(cfg *config.Config, device string) (func() (transport.Transport, error), error) {
+	if device == "" {
+		return func() (transport.Transport, error) { return nil, server.ErrNoPrinter }, nil
+	}
+
+	pc := cfg.Printer
+	if pc.Device != device {
+		// Picked in this session but not saved, the fingerprint is the
+		// saved printer's
+		pc.Fingerprint = ""
+	}
+	return discovery.Printer(discovery.Configure(pc, device), "")
+}
+
+// detectAllPrinters returns the likely printers, best first, and logs why
+// each device found was offered or not
+func detectAllPrinters() ([]discovery.PrinterInfo, error) {
+	providers := discovery.Default()
+	if providers == nil {
+		return nil, errors.New("Windows and Linux only")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	report := discovery.Discover(ctx, append(providers, discovery.Probe{})...)
+	fmt.Println("[DEBUG] Printer discovery (+ offered, - skipped, score, port, name, reasons):")
+	report.WriteTo(os.Stdout)
+
+	printers := report.Printers()
+	if len(printers) == 0 {
+		return nil, errors.New("No printers found. Please ensure your printer is connected via Bluetooth or USB")
+	}
+	return printers, nil
+}
+
+// resolveSelection points a saved printer selection at the port its
+// printer has now, e.g. after Windows renumbered the COM port of a
+// re-paired Bluetooth printer
+func resolveSelection() {
+	cfg := settings.Get()
+	if cfg.Printer.Fingerprint == "" {
+		return
+	}
+
+	printers, _ := detectAllPrinters()
+	pc, ok := discovery.Resolve(printers, cfg.Printer)
+	if !ok {
+		fmt.Printf("[CONFIG] Saved printer %s not found, using %s\n", cfg.Printer.Fingerprint, cfg.Printer.Device)
+		return
+	}
+	selectedPrinterCOM = pc.Device
+	if pc.Device != cfg.Printer.Device {
+		fmt.Printf("[CONFIG] Printer %s moved from %s to %s\n", pc.Fingerprint, cfg.Printer.Device, pc.Device)
+		savePrinter(pc)
+	}
+}
+
+// saveSelection remembers printer p by its fingerprint, so the next start
+// finds it again on whatever port it has then
+func saveSelection(p discovery.PrinterInfo) {
+	cfg := settings.Get()
+	if pc := discovery.Select(cfg.Printer, p); pc != cfg.Printer {
+		savePrinter(pc)
+	}
+}
+
+func savePrinter(pc transport.Config) {
+	path := settings.Get().SavePath()
+	if err := config.SavePrinter(path, pc); err != nil {
+		fmt.Println("[CONFIG] Could not save the printer selection:", err)
+		return
+	}
+	fmt.Printf("[CONFIG] Saved printer %s (%s) to %s\n", pc.Device, pc.Fingerprint, path)
+	if _, _, err := settings.Reload(); err != nil {
+		fmt.Println("[CONFIG] Reload error:", err)
+	}
+}
@@ -0,0 +1,96 @@
+// Package auth checks the API keys POS terminals send as
+// "Authorization: Bearer <key>".
+//
+// Only a SHA-256 hash of each key is kept in the config file, so a copied
+// printer-config.json does not leak working keys. Keys are long random
+// strings, which makes a plain hash as good as a slow password hash here.
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// hashPrefix marks the hash algorithm in Key.Hash
+const hashPrefix = "sha256:"
+
+// keyPrefix makes agent keys recognisable in logs and secret scanners
+const keyPrefix = "clk_"
+
+// Key is one named API key, e.g. for a POS terminal or branch
+type Key struct {
+	Name    string `json:"name"`
+	Hash    string `json:"hash"`              // "sha256:" + hex digest of the key
+	Revoked bool   `json:"revoked,omitempty"` // Revoked keys are kept for reference but rejected
+}
+
+// Generate returns a new random key and its config entry
+func Generate(name string) (secret string, key Key, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", Key{}, err
+	}
+	secret = keyPrefix + base64.RawURLEncoding.EncodeToString(b)
+	return secret, Key{Name: name, Hash: Hash(secret)}, nil
+}
+
+// Hash returns the value stored in Key.Hash for secret
+func Hash(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hashPrefix + hex.EncodeToString(sum[:])
+}
+
+// Validate checks that the key has a name and a well-formed hash
+func (k Key) Validate() error {
+	if strings.TrimSpace(k.Name) == "" {
+		return errors.New("name is required")
+	}
+	digest, ok := strings.CutPrefix(k.Hash, hashPrefix)
+	if !ok {
+		return fmt.Errorf("hash must start with %q", hashPrefix)
+	}
+	if b, err := hex.DecodeString(digest); err != nil || len(b) != sha256.Size {
+		return errors.New("hash must be 64 hex digits")
+	}
+	return nil
+}
+
+// Match returns the active key that secret belongs to. Every key is
+// compared in constant time so response timing does not reveal how close
+// a guess was.
+func Match(keys []Key, secret string) (Key, bool) {
+	sum := sha256.Sum256([]byte(secret))
+
+	var found Key
+	ok := false
+	for _, k := range keys {
+		digest, _ := hex.DecodeString(strings.TrimPrefix(k.Hash, hashPrefix))
+		if subtle.ConstantTimeCompare(sum[:], digest) == 1 && !k.Revoked && !ok {
+			found, ok = k, true
+		}
+	}
+	return found, ok
+}
+
+// Equal compares two plain tokens in constant time
+func Equal(a, b string) bool {
+	x, y := sha256.Sum256([]byte(a)), sha256.Sum256([]byte(b))
+	return subtle.ConstantTimeCompare(x[:], y[:]) == 1
+}
+
+// Bearer returns the token from an "Authorization: Bearer <token>" header
+func Bearer(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestGenerateAndMatch(t *testing.T) {
+	secret, key, err := Generate("Kasir 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(secret, keyPrefix) || strings.Contains(key.Hash, secret) {
+		t.Fatalf("unexpected key %q -> %+v", secret, key)
+	}
+	if err := key.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	other, otherKey, _ := Generate("Kasir 2")
+	keys := []Key{key, otherKey}
+
+	if k, ok := Match(keys, secret); !ok || k.Name != "Kasir 1" {
+		t.Errorf("Match(secret) = %+v, %v", k, ok)
+	}
+	if k, ok := Match(keys, other); !ok || k.Name != "Kasir 2" {
+		t.Errorf("Match(other) = %+v, %v", k, ok)
+	}
+	if _, ok := Match(keys, secret+"x"); ok {
+		t.Error("wrong key accepted")
+	}
+	if _, ok := Match(keys, ""); ok {
+		t.Error("empty key accepted")
+	}
+
+	keys[0].Revoked = true
+	if _, ok := Match(keys, secret); ok {
+		t.Error("revoked key accepted")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, k := range []Key{
+		{Hash: Hash("x")},
+		{Name: "a", Hash: "x"},
+		{Name: "a", Hash: "sha256:abc"},
+		{Name: "a", Hash: "md5:" + strings.Repeat("0", 64)},
+	} {
+		if k.Validate() == nil {
+			t.Errorf("%+v accepted", k)
+		}
+	}
+}
+
+func TestBearer(t *testing.T) {
+	for header, want := range map[string]string{
+		"Bearer abc":  "abc",
+		"bearer abc":  "abc",
+		"Basic abc":   "",
+		"Bearer ":     "",
+		"":            "",
+		"Bearerabc":   "",
+		"Bearer  abc": "abc",
+	} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Authorization", header)
+		if got, ok := Bearer(r); got != want || ok != (want != "") {
+			t.Errorf("Bearer(%q) = %q, %v", header, got, ok)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Headers of a signed request. The signature is
+// "sha256=" + hex(HMAC-SHA256(secret, timestamp + "." + body)), where
+// timestamp is the value of TimestampHeader in Unix seconds.
+const (
+	TimestampHeader = "X-Cleanlink-Timestamp"
+	SignatureHeader = "X-Cleanlink-Signature"
+)
+
+const signaturePrefix = "sha256="
+
+// Signature errors
+var (
+	ErrNoSignature   = errors.New("request is not signed")
+	ErrBadTimestamp  = errors.New("timestamp is missing or outside the allowed window")
+	ErrBadSignature  = errors.New("signature does not match")
+	ErrReplayedNonce = errors.New("request was already received")
+)
+
+// Sign returns the signature header value for body sent at timestamp
+func Sign(secret string, timestamp int64, body []byte) string {
+	return signaturePrefix + hex.EncodeToString(sum(secret, timestamp, body))
+}
+
+func sum(secret string, timestamp int64, body []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return mac.Sum(nil)
+}
+
+// Verifier checks signed requests and rejects each signature after its
+// first use, so a captured request cannot be printed twice
+type Verifier struct {
+	mu   sync.Mutex
+	seen map[string]time.Time // signature -> when it may be forgotten
+	now  func() time.Time
+}
+
+// Nonce is the signature of a verified request. It is only used up once
+// passed to Use, so a request rejected for another reason can be sent
+// again with the same signature.
+type Nonce struct {
+	signature string
+	expires   time.Time
+}
+
+// NewVerifier returns a verifier with an empty nonce cache
+func NewVerifier() *Verifier {
+	return &Verifier{seen: map[string]time.Time{}, now: time.Now}
+}
+
+// Verify checks the timestamp and signature headers of a request with
+// body and returns the nonce to pass to Use once the request is accepted.
+// Requests more than window away from the local clock are rejected. The
+// hex signature may be in either case.
+func (v *Verifier) Verify(secret string, window time.Duration, timestamp, signature string, body []byte) (Nonce, error) {
+	if timestamp == "" && signature == "" {
+		return Nonce{}, ErrNoSignature
+	}
+
+	now := v.now()
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return Nonce{}, ErrBadTimestamp
+	}
+	if skew := now.Sub(time.Unix(ts, 0)); skew > window || skew < -window {
+		return Nonce{}, ErrBadTimestamp
+	}
+
+	hexSum, ok := strings.CutPrefix(signature, signaturePrefix)
+	if !ok {
+		return Nonce{}, ErrBadSignature
+	}
+	got, err := hex.DecodeString(hexSum)
+	if err != nil || !hmac.Equal(got, sum(secret, ts, body)) {
+		return Nonce{}, ErrBadSignature
+	}
+
+	// After this the timestamp is outside the window anyway
+	n := Nonce{signature: string(got), expires: time.Unix(ts, 0).Add(window)}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.forget(now)
+	if _, ok := v.seen[n.signature]; ok {
+		return Nonce{}, ErrReplayedNonce
+	}
+	return n, nil
+}
+
+// Use records n as used. It fails if a request with the same signature was
+// accepted since n was verified. The zero Nonce, from a server that does
+// not require signatures, is always accepted.
+func (v *Verifier) Use(n Nonce) error {
+	if n.signature == "" {
+		return nil
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.forget(v.now())
+	if _, ok := v.seen[n.signature]; ok {
+		return ErrReplayedNonce
+	}
+	v.seen[n.signature] = n.expires
+	return nil
+}
+
+// forget drops the signatures whose timestamp has left the window. The
+// caller holds v.mu.
+func (v *Verifier) forget(now time.Time) {
+	for sig, expires := range v.seen {
+		if now.After(expires) {
+			delete(v.seen, sig)
+		}
+	}
+}
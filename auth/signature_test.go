@@ -0,0 +1,92 @@
+package auth
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerify(t *testing.T) {
+	const secret = "shared-secret"
+	now := time.Unix(1766396701, 0)
+	v := NewVerifier()
+	v.now = func() time.Time { return now }
+
+	body := []byte(`{"order_id":"ORD-1"}`)
+	ts := strconv.FormatInt(now.Unix(), 10)
+	sig := Sign(secret, now.Unix(), body)
+
+	// check verifies a request and, like the server, uses its nonce once
+	// it is accepted
+	check := func(name string, ts, sig string, body []byte, want error) {
+		t.Helper()
+		n, err := v.Verify(secret, time.Minute, ts, sig, body)
+		if err == nil {
+			err = v.Use(n)
+		}
+		if !errors.Is(err, want) {
+			t.Errorf("%s: err = %v, want %v", name, err, want)
+		}
+	}
+
+	check("unsigned", "", "", body, ErrNoSignature)
+	check("tampered body", ts, sig, []byte(`{"order_id":"ORD-2"}`), ErrBadSignature)
+	check("wrong secret", ts, Sign("other", now.Unix(), body), body, ErrBadSignature)
+	check("bad timestamp", "yesterday", sig, body, ErrBadTimestamp)
+	check("not hex", ts, "sha256=zz", body, ErrBadSignature)
+	check("no prefix", ts, strings.TrimPrefix(sig, "sha256="), body, ErrBadSignature)
+
+	old := now.Add(-2 * time.Minute).Unix()
+	check("expired", strconv.FormatInt(old, 10), Sign(secret, old, body), body, ErrBadTimestamp)
+	future := now.Add(2 * time.Minute).Unix()
+	check("from the future", strconv.FormatInt(future, 10), Sign(secret, future, body), body, ErrBadTimestamp)
+
+	// A verified request rejected later, e.g. for a wrong API key, does
+	// not use up its signature
+	if _, err := v.Verify(secret, time.Minute, ts, sig, body); err != nil {
+		t.Fatal(err)
+	}
+	check("valid", ts, sig, body, nil)
+	check("replayed", ts, sig, body, ErrReplayedNonce)
+	check("replayed in uppercase", ts, "sha256="+strings.ToUpper(strings.TrimPrefix(sig, "sha256=")), body, ErrReplayedNonce)
+
+	// Two requests verified before either was accepted
+	other := []byte(`{"order_id":"ORD-3"}`)
+	otherSig := Sign(secret, now.Unix(), other)
+	a, errA := v.Verify(secret, time.Minute, ts, otherSig, other)
+	b, errB := v.Verify(secret, time.Minute, ts, otherSig, other)
+	if errA != nil || errB != nil {
+		t.Fatal(errA, errB)
+	}
+	if err := v.Use(a); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Use(b); !errors.Is(err, ErrReplayedNonce) {
+		t.Errorf("second use: err = %v, want %v", err, ErrReplayedNonce)
+	}
+
+	// Forgotten once the timestamp has left the window
+	now = now.Add(2 * time.Minute)
+	check("expired replay", ts, sig, body, ErrBadTimestamp)
+	ts = strconv.FormatInt(now.Unix(), 10)
+	check("next request", ts, Sign(secret, now.Unix(), body), body, nil)
+	if len(v.seen) != 1 {
+		t.Errorf("nonce cache holds %d entries, want 1", len(v.seen))
+	}
+}
+
+func TestVerifyUppercaseHex(t *testing.T) {
+	const secret = "shared-secret"
+	now := time.Unix(1766396701, 0)
+	v := NewVerifier()
+	v.now = func() time.Time { return now }
+
+	body := []byte(`{"order_id":"ORD-1"}`)
+	sig := Sign(secret, now.Unix(), body)
+	upper := "sha256=" + strings.ToUpper(strings.TrimPrefix(sig, "sha256="))
+	if _, err := v.Verify(secret, time.Minute, strconv.FormatInt(now.Unix(), 10), upper, body); err != nil {
+		t.Errorf("uppercase hex: %v", err)
+	}
+}
@@ -0,0 +1,223 @@
+// Package certs creates the local certificate authority and localhost
+// certificate the agent serves HTTPS with, so an https POS page can call
+// it without mixed-content errors.
+//
+// The CA is generated once per PC and installed by the user from
+// GET /cert. It is name-constrained to localhost and loopback addresses,
+// so even a stolen CA key cannot be used to impersonate other sites.
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Files kept in the certificate directory
+const (
+	CAFile      = "ca.pem"
+	CAKeyFile   = "ca-key.pem"
+	CertFile    = "localhost.pem"
+	CertKeyFile = "localhost-key.pem"
+)
+
+const (
+	caValidity   = 10 * 365 * 24 * time.Hour
+	certValidity = 397 * 24 * time.Hour // Longest leaf lifetime browsers accept
+	renewBefore  = 30 * 24 * time.Hour
+)
+
+// now is replaced in tests
+var now = time.Now
+
+// Bundle is the local CA and the localhost certificate it signed
+type Bundle struct {
+	CA    *x509.Certificate
+	CAPEM []byte // The CA certificate for users to install
+	Cert  tls.Certificate
+
+	caKey *ecdsa.PrivateKey
+}
+
+// LoadOrCreate loads the certificates from dir, creating the CA on first
+// run and a new localhost certificate when the old one is missing or
+// about to expire
+func LoadOrCreate(dir string) (*Bundle, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	b, err := loadCA(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		fmt.Println("[TLS] Creating local certificate authority in", dir)
+		b, err = createCA(dir)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	b.Cert, err = tls.LoadX509KeyPair(filepath.Join(dir, CertFile), filepath.Join(dir, CertKeyFile))
+	if err != nil || expiresSoon(b.Cert) || b.verify() != nil {
+		fmt.Println("[TLS] Issuing localhost certificate")
+		if err := b.issue(dir); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// TLSConfig returns a server config that presents the localhost certificate
+func (b *Bundle) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{b.Cert},
+	}
+}
+
+func loadCA(dir string) (*Bundle, error) {
+	certPEM, err := os.ReadFile(filepath.Join(dir, CAFile))
+	if err != nil {
+		return nil, err
+	}
+	keyPEM, err := os.ReadFile(filepath.Join(dir, CAKeyFile))
+	if err != nil {
+		return nil, err
+	}
+
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("load %s: %w", CAFile, err)
+	}
+	key, ok := pair.PrivateKey.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("load %s: unsupported key type", CAKeyFile)
+	}
+	return &Bundle{CA: pair.Leaf, CAPEM: certPEM, caKey: key}, nil
+}
+
+func createCA(dir string) (*Bundle, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	host, _ := os.Hostname()
+	template := &x509.Certificate{
+		SerialNumber: serial(),
+		Subject: pkix.Name{
+			Organization: []string{"Cleanlink"},
+			CommonName:   "Cleanlink Printer Agent Local CA " + host,
+		},
+		NotBefore:             now().Add(-time.Hour),
+		NotAfter:              now().Add(caValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+
+		PermittedDNSDomainsCritical: true,
+		PermittedDNSDomains:         []string{"localhost"},
+		PermittedIPRanges:           loopbackRanges(),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	ca, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	if err := writeKey(filepath.Join(dir, CAKeyFile), key); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(dir, CAFile), certPEM, 0644); err != nil {
+		return nil, err
+	}
+	return &Bundle{CA: ca, CAPEM: certPEM, caKey: key}, nil
+}
+
+// issue signs a new localhost certificate with the CA
+func (b *Bundle) issue(dir string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serial(),
+		Subject:      pkix.Name{Organization: []string{"Cleanlink"}, CommonName: "localhost"},
+		NotBefore:    now().Add(-time.Hour),
+		NotAfter:     now().Add(certValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, b.CA, &key.PublicKey, b.caKey)
+	if err != nil {
+		return err
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return err
+	}
+
+	if err := writeKey(filepath.Join(dir, CertKeyFile), key); err != nil {
+		return err
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	if err := os.WriteFile(filepath.Join(dir, CertFile), certPEM, 0644); err != nil {
+		return err
+	}
+
+	b.Cert = tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+	return nil
+}
+
+// verify checks that the localhost certificate was signed by this CA, e.g.
+// after ca.pem was replaced
+func (b *Bundle) verify() error {
+	roots := x509.NewCertPool()
+	roots.AddCert(b.CA)
+	_, err := b.Cert.Leaf.Verify(x509.VerifyOptions{DNSName: "localhost", Roots: roots, CurrentTime: now()})
+	return err
+}
+
+func expiresSoon(cert tls.Certificate) bool {
+	return cert.Leaf == nil || now().Add(renewBefore).After(cert.Leaf.NotAfter)
+}
+
+func writeKey(path string, key *ecdsa.PrivateKey) error {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600)
+}
+
+func serial() *big.Int {
+	n, _ := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	return n
+}
+
+func loopbackRanges() []*net.IPNet {
+	return []*net.IPNet{
+		{IP: net.IPv4(127, 0, 0, 0), Mask: net.CIDRMask(8, 32)},
+		{IP: net.IPv6loopback, Mask: net.CIDRMask(128, 128)},
+	}
+}
@@ -0,0 +1,110 @@
+package certs
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadOrCreate(t *testing.T) {
+	dir := t.TempDir()
+
+	b, err := LoadOrCreate(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !b.CA.IsCA || b.Cert.Leaf.NotAfter.Sub(b.Cert.Leaf.NotBefore) > 398*24*time.Hour {
+		t.Errorf("unexpected certificates: CA %v, leaf valid until %s", b.CA.IsCA, b.Cert.Leaf.NotAfter)
+	}
+
+	for _, name := range []string{CAKeyFile, CertKeyFile} {
+		info, err := os.Stat(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if perm := info.Mode().Perm(); perm&0077 != 0 && os.PathSeparator == '/' {
+			t.Errorf("%s is readable by others: %s", name, perm)
+		}
+	}
+
+	// The second run reuses both
+	again, err := LoadOrCreate(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(again.CAPEM, b.CAPEM) || !bytes.Equal(again.Cert.Certificate[0], b.Cert.Certificate[0]) {
+		t.Error("certificates regenerated on restart")
+	}
+}
+
+func TestRenewal(t *testing.T) {
+	dir := t.TempDir()
+	b, _ := LoadOrCreate(dir)
+
+	now = func() time.Time { return time.Now().Add(380 * 24 * time.Hour) }
+	defer func() { now = time.Now }()
+
+	renewed, err := LoadOrCreate(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(renewed.CAPEM, b.CAPEM) {
+		t.Error("CA replaced on renewal")
+	}
+	if !renewed.Cert.Leaf.NotAfter.After(b.Cert.Leaf.NotAfter) {
+		t.Error("expiring certificate not renewed")
+	}
+}
+
+func TestNameConstraints(t *testing.T) {
+	b, _ := LoadOrCreate(t.TempDir())
+
+	roots := x509.NewCertPool()
+	roots.AddCert(b.CA)
+	for _, host := range []string{"localhost", "127.0.0.1", "::1"} {
+		if _, err := b.Cert.Leaf.Verify(x509.VerifyOptions{DNSName: host, Roots: roots}); err != nil {
+			t.Errorf("%s: %v", host, err)
+		}
+	}
+
+	// A certificate for another site signed with the CA key is rejected
+	template := *b.Cert.Leaf
+	template.DNSNames = []string{"bank.example"}
+	template.IPAddresses = nil
+	der, err := x509.CreateCertificate(rand.Reader, &template, b.CA, b.Cert.Leaf.PublicKey, b.caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged, _ := x509.ParseCertificate(der)
+	if _, err := forged.Verify(x509.VerifyOptions{DNSName: "bank.example", Roots: roots}); err == nil {
+		t.Error("CA can sign certificates for other domains")
+	}
+}
+
+func TestServeTLS(t *testing.T) {
+	b, _ := LoadOrCreate(t.TempDir())
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	srv.TLS = b.TLSConfig()
+	srv.StartTLS()
+	defer srv.Close()
+
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(b.CAPEM)
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}}
+
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+}
@@ -0,0 +1,614 @@
+// Package config loads agent settings from printer-config.json, environment
+// variables and command-line flags, so one binary can be shipped to every
+// branch.
+//
+// Later sources override earlier ones: built-in defaults, then the JSON
+// file, then CLEANLINK_* environment variables, then flags.
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"cleanlink/printer/auth"
+	"cleanlink/printer/cors"
+	"cleanlink/printer/jsontime"
+	"cleanlink/printer/render"
+	"cleanlink/printer/transport"
+)
+
+// DefaultPath is the config file looked up when none is given
+const DefaultPath = "printer-config.json"
+
+// LegacyToken is the token every agent accepted before API keys. It is
+// only honoured when legacy_body_token is enabled.
+const LegacyToken = "CLEANLINK_SECRET_123"
+
+// Config holds the agent settings
+type Config struct {
+	// Listen is the HTTP listen address. Only loopback addresses are
+	// allowed unless LAN is set.
+	Listen string `json:"listen"`
+	// HTTPSListen is the HTTPS listen address; empty disables HTTPS
+	HTTPSListen string `json:"https_listen"`
+	// CertDir holds the local CA and localhost certificate for HTTPS
+	CertDir string `json:"cert_dir"`
+	// LAN allows listening on a network interface so tablets can print to
+	// this agent. It requires API keys and AllowedIPs.
+	LAN bool `json:"lan"`
+	// AllowedIPs are the client IPs or CIDR ranges served in LAN mode, e.g.
+	// "192.168.1.0/24". Loopback is always allowed.
+	AllowedIPs []string `json:"allowed_ips"`
+	// APIKeys are the keys accepted as "Authorization: Bearer <key>"
+	APIKeys []auth.Key `json:"api_keys"`
+	// LegacyBodyToken accepts Tokens in the "token" field of the print
+	// request body, for POS builds that predate API keys
+	LegacyBodyToken bool `json:"legacy_body_token"`
+	// Tokens are the plain tokens accepted in the request body when
+	// LegacyBodyToken is set
+	Tokens []string `json:"tokens"`
+	// AllowedOrigins are the web origins a browser may call the agent from,
+	// e.g. "https://pos.cleanlink.com" or "https://*.cleanlink.com"
+	AllowedOrigins []string `json:"allowed_origins"`
+	// SigningSecret turns on HMAC request signing: print requests must
+	// carry a signature made with this secret by the POS backend
+	SigningSecret string `json:"signing_secret"`
+	// SigningWindow is how far a signed request's timestamp may be from the
+	// agent's clock
+	SigningWindow jsontime.Duration `json:"signing_window"`
+	// DeviceID is a partial printer name used when auto-detecting the
+	// printer, e.g. "RPP02N"
+	DeviceID string `json:"device_id"`
+	// Printer selects the transport. For COM and tty printers an empty device
+	// means the port is detected at startup and whenever it stops working.
+	Printer transport.Config `json:"printer"`
+	// Printers are further printers by name that Routes can send jobs to,
+	// e.g. a label printer in the back room. Each needs a device.
+	Printers map[string]transport.Config `json:"printers"`
+	// Routes maps a print mode to the name of the printer that prints it,
+	// e.g. {"qr-only": "labels"}. An "all" job is split into its receipt
+	// and QR labels when they go to different printers. Modes without a
+	// route, and the name "default", print on Printer.
+	Routes map[string]string `json:"routes"`
+	// PaperWidth is the paper width in millimetres (57, 58 or 80)
+	PaperWidth int `json:"paper_width"`
+	// DefaultPrintMode is used when a request has no print_mode. Empty
+	// keeps the automatic choice based on the QR codes in the request.
+	DefaultPrintMode string `json:"default_print_mode"`
+	// QueueDir is where print jobs are kept until the printer accepts them
+	QueueDir string `json:"queue_dir"`
+	// IdempotencyWindow is how long a resubmitted job returns the original
+	// job instead of printing again; 0 disables deduplication
+	IdempotencyWindow jsontime.Duration `json:"idempotency_window"`
+	// StatusMonitor keeps serial and network printers connected with
+	// automatic status back, so paper and cover problems pause the queue
+	// and are reported on /events as they happen
+	StatusMonitor bool `json:"status_monitor"`
+
+	// Path is the config file the settings were read from, if any
+	Path string `json:"-"`
+}
+
+// Default returns the built-in defaults
+func Default() Config {
+	return Config{
+		Listen:     "127.0.0.1:3491",
+		Tokens:     []string{LegacyToken},
+		Printer:    transport.Config{Type: transport.KindCOM},
+		PaperWidth: 58,
+		QueueDir:   "print-jobs",
+		CertDir:    "tls",
+
+		StatusMonitor: true,
+
+		IdempotencyWindow: jsontime.Duration(10 * time.Minute),
+		SigningWindow:     jsontime.Duration(5 * time.Minute),
+	}
+}
+
+// usageOutput receives the flag list on -h or a mistyped flag; tests
+// replace it
+var usageOutput io.Writer = os.Stderr
+
+// Load applies the config file, environment and command-line args on top
+// of defaults and validates the result
+func Load(defaults Config, args []string) (*Config, error) {
+	cfg := defaults
+	cfg.Tokens = append([]string(nil), defaults.Tokens...)
+	cfg.APIKeys = append([]auth.Key(nil), defaults.APIKeys...)
+	cfg.AllowedOrigins = append([]string(nil), defaults.AllowedOrigins...)
+	cfg.AllowedIPs = append([]string(nil), defaults.AllowedIPs...)
+	cfg.Printers = maps.Clone(defaults.Printers)
+	cfg.Routes = maps.Clone(defaults.Routes)
+
+	fs := flag.NewFlagSet("cleanlink-printer", flag.ContinueOnError)
+	fs.SetOutput(usageOutput)
+	path := fs.String("config", "", "path to the config file (default "+DefaultPath+")")
+	listen := fs.String("listen", "", "HTTP listen address, e.g. 127.0.0.1:3491")
+	httpsListen := fs.String("https-listen", "", "HTTPS listen address, e.g. 127.0.0.1:3492")
+	certDir := fs.String("cert-dir", "", "directory for the local HTTPS certificates")
+	lan := fs.Bool("lan", false, "allow listening on the local network")
+	allowedIPs := fs.String("allowed-ips", "", "client IPs or CIDRs served in LAN mode (comma separated)")
+	token := fs.String("token", "", "legacy body token (comma separated for several)")
+	legacyBodyToken := fs.Bool("legacy-body-token", false, "accept the token in the print request body")
+	allowedOrigins := fs.String("allowed-origins", "", "web origins allowed to call the agent (comma separated)")
+	signingSecret := fs.String("signing-secret", "", "shared secret for HMAC request signing")
+	signingWindow := fs.Duration("signing-window", 0, "allowed clock difference for signed requests")
+	deviceID := fs.String("device-id", "", "partial printer name used for auto-detection")
+	printerType := fs.String("printer-type", "", "printer transport: com, tty, file, tcp or memory")
+	printerDevice := fs.String("printer-device", "", "printer port, device, file or host:port")
+	printerFingerprint := fs.String("printer-fingerprint", "", "identity of a discovered printer, its port is looked up on startup")
+	paperWidth := fs.Int("paper-width", 0, "paper width in mm: 57, 58 or 80")
+	printMode := fs.String("print-mode", "", "default print mode")
+	queueDir := fs.String("queue-dir", "", "directory for queued print jobs")
+	idempotencyWindow := fs.Duration("idempotency-window", 0, "how long duplicate submissions return the original job")
+	statusMonitor := fs.Bool("status-monitor", false, "keep the printer connected and report status changes")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	// 1. Config file
+	if *path == "" {
+		*path = os.Getenv("CLEANLINK_CONFIG")
+	}
+	if err := cfg.readFile(*path); err != nil {
+		return nil, err
+	}
+
+	// 2. Environment
+	if err := cfg.applyEnv(); err != nil {
+		return nil, err
+	}
+
+	// 3. Flags
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	if set["listen"] {
+		cfg.Listen = *listen
+	}
+	if set["https-listen"] {
+		cfg.HTTPSListen = *httpsListen
+	}
+	if set["cert-dir"] {
+		cfg.CertDir = *certDir
+	}
+	if set["lan"] {
+		cfg.LAN = *lan
+	}
+	if set["allowed-ips"] {
+		cfg.AllowedIPs = splitList(*allowedIPs)
+	}
+	if set["token"] {
+		cfg.Tokens = splitList(*token)
+	}
+	if set["legacy-body-token"] {
+		cfg.LegacyBodyToken = *legacyBodyToken
+	}
+	if set["allowed-origins"] {
+		cfg.AllowedOrigins = splitList(*allowedOrigins)
+	}
+	if set["signing-secret"] {
+		cfg.SigningSecret = *signingSecret
+	}
+	if set["signing-window"] {
+		cfg.SigningWindow = jsontime.Duration(*signingWindow)
+	}
+	if set["device-id"] {
+		cfg.DeviceID = *deviceID
+	}
+	if set["printer-type"] {
+		cfg.Printer.Type = *printerType
+	}
+	if set["printer-device"] {
+		cfg.Printer.Device = *printerDevice
+	}
+	if set["printer-fingerprint"] {
+		cfg.Printer.Fingerprint = *printerFingerprint
+	}
+	if set["paper-width"] {
+		cfg.PaperWidth = *paperWidth
+	}
+	if set["print-mode"] {
+		cfg.DefaultPrintMode = *printMode
+	}
+	if set["queue-dir"] {
+		cfg.QueueDir = *queueDir
+	}
+	if set["idempotency-window"] {
+		cfg.IdempotencyWindow = jsontime.Duration(*idempotencyWindow)
+	}
+	if set["status-monitor"] {
+		cfg.StatusMonitor = *statusMonitor
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// readFile merges the JSON file at path into c. When path is empty the
+// default file is used if it exists in the working directory or next to
+// the executable.
+func (c *Config) readFile(path string) error {
+	explicit := path != ""
+	if !explicit {
+		path = findDefault()
+		if path == "" {
+			return nil
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !explicit && errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("read config: %w", err)
+	}
+
+	if err := json.Unmarshal(data, c); err != nil {
+		return fmt.Errorf("parse %s: %w", path, err)
+	}
+	c.Path = path
+	return nil
+}
+
+func findDefault() string {
+	if _, err := os.Stat(DefaultPath); err == nil {
+		return DefaultPath
+	}
+	if exe, err := os.Executable(); err == nil {
+		path := filepath.Join(filepath.Dir(exe), DefaultPath)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+func (c *Config) applyEnv() error {
+	if v, ok := os.LookupEnv("CLEANLINK_LISTEN"); ok {
+		c.Listen = v
+	}
+	if v, ok := os.LookupEnv("CLEANLINK_HTTPS_LISTEN"); ok {
+		c.HTTPSListen = v
+	}
+	if v, ok := os.LookupEnv("CLEANLINK_CERT_DIR"); ok {
+		c.CertDir = v
+	}
+	if v, ok := os.LookupEnv("CLEANLINK_LAN"); ok {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("CLEANLINK_LAN: %q is not true or false", v)
+		}
+		c.LAN = b
+	}
+	if v, ok := os.LookupEnv("CLEANLINK_ALLOWED_IPS"); ok {
+		c.AllowedIPs = splitList(v)
+	}
+	if v, ok := os.LookupEnv("CLEANLINK_TOKEN"); ok {
+		c.Tokens = splitList(v)
+	}
+	if v, ok := os.LookupEnv("CLEANLINK_LEGACY_BODY_TOKEN"); ok {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("CLEANLINK_LEGACY_BODY_TOKEN: %q is not true or false", v)
+		}
+		c.LegacyBodyToken = b
+	}
+	if v, ok := os.LookupEnv("CLEANLINK_ALLOWED_ORIGINS"); ok {
+		c.AllowedOrigins = splitList(v)
+	}
+	if v, ok := os.LookupEnv("CLEANLINK_SIGNING_SECRET"); ok {
+		c.SigningSecret = v
+	}
+	if v, ok := os.LookupEnv("CLEANLINK_SIGNING_WINDOW"); ok {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("CLEANLINK_SIGNING_WINDOW: %w", err)
+		}
+		c.SigningWindow = jsontime.Duration(d)
+	}
+	if v, ok := os.LookupEnv("CLEANLINK_DEVICE_ID"); ok {
+		c.DeviceID = v
+	}
+	if v, ok := os.LookupEnv("CLEANLINK_PRINTER_TYPE"); ok {
+		c.Printer.Type = v
+	}
+	if v, ok := os.LookupEnv("CLEANLINK_PRINTER_DEVICE"); ok {
+		c.Printer.Device = v
+	}
+	if v, ok := os.LookupEnv("CLEANLINK_PRINTER_FINGERPRINT"); ok {
+		c.Printer.Fingerprint = v
+	}
+	if v, ok := os.LookupEnv("CLEANLINK_PAPER_WIDTH"); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("CLEANLINK_PAPER_WIDTH: %q is not a number", v)
+		}
+		c.PaperWidth = n
+	}
+	if v, ok := os.LookupEnv("CLEANLINK_PRINT_MODE"); ok {
+		c.DefaultPrintMode = v
+	}
+	if v, ok := os.LookupEnv("CLEANLINK_QUEUE_DIR"); ok {
+		c.QueueDir = v
+	}
+	if v, ok := os.LookupEnv("CLEANLINK_IDEMPOTENCY_WINDOW"); ok {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("CLEANLINK_IDEMPOTENCY_WINDOW: %w", err)
+		}
+		c.IdempotencyWindow = jsontime.Duration(d)
+	}
+	if v, ok := os.LookupEnv("CLEANLINK_STATUS_MONITOR"); ok {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("CLEANLINK_STATUS_MONITOR: %q is not true or false", v)
+		}
+		c.StatusMonitor = b
+	}
+	return nil
+}
+
+// Validate reports every problem with the settings at once
+func (c *Config) Validate() error {
+	var errs []error
+
+	if err := c.checkListen(c.Listen); err != nil {
+		errs = append(errs, fmt.Errorf("listen: %w", err))
+	}
+	if c.HTTPSListen != "" {
+		if err := c.checkListen(c.HTTPSListen); err != nil {
+			errs = append(errs, fmt.Errorf("https_listen: %w", err))
+		} else if port(c.HTTPSListen) == port(c.Listen) {
+			errs = append(errs, errors.New("https_listen: must use a different port than listen"))
+		}
+		if c.CertDir == "" {
+			errs = append(errs, errors.New("cert_dir: a directory is required for HTTPS"))
+		}
+	}
+
+	for i, s := range c.AllowedIPs {
+		if _, err := parsePrefix(s); err != nil {
+			errs = append(errs, fmt.Errorf("allowed_ips[%d]: %w", i, err))
+		}
+	}
+	if c.LAN {
+		if c.ActiveKeys() == 0 {
+			errs = append(errs, errors.New("lan: requires at least one API key in api_keys"))
+		}
+		if len(c.AllowedIPs) == 0 {
+			errs = append(errs, errors.New("allowed_ips: lan requires at least one IP or CIDR range"))
+		}
+		if c.LegacyBodyToken {
+			errs = append(errs, errors.New("legacy_body_token: not allowed with lan, body tokens are shared and sent in plain text; use api_keys"))
+		}
+	}
+
+	names := map[string]bool{}
+	for i, k := range c.APIKeys {
+		if err := k.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("api_keys[%d]: %w", i, err))
+		}
+		if names[k.Name] {
+			errs = append(errs, fmt.Errorf("api_keys[%d]: duplicate name %q", i, k.Name))
+		}
+		names[k.Name] = true
+	}
+
+	if c.LegacyBodyToken && len(c.Tokens) == 0 {
+		errs = append(errs, errors.New("tokens: legacy_body_token needs at least one token"))
+	}
+	for i, t := range c.Tokens {
+		if strings.TrimSpace(t) == "" {
+			errs = append(errs, fmt.Errorf("tokens[%d]: empty token", i))
+		}
+	}
+
+	if _, err := cors.Parse(c.AllowedOrigins); err != nil {
+		errs = append(errs, fmt.Errorf("allowed_origins: %w", err))
+	}
+
+	if c.SigningSecret != "" && len(c.SigningSecret) < 16 {
+		errs = append(errs, errors.New("signing_secret: must be at least 16 characters"))
+	}
+	if c.SigningWindow <= 0 {
+		errs = append(errs, errors.New("signing_window: must be positive"))
+	}
+
+	if !c.AutoDetect() {
+		if _, err := transport.New(c.Printer); err != nil {
+			errs = append(errs, fmt.Errorf("printer: %w", err))
+		}
+	} else if err := c.Printer.SerialOptions.Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("printer: %w", err))
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(c.Printers)) {
+		if name == "" || name == "default" {
+			errs = append(errs, fmt.Errorf("printers: %q cannot name a printer", name))
+		}
+		if _, err := transport.New(c.Printers[name]); err != nil {
+			errs = append(errs, fmt.Errorf("printers.%s: %w", name, err))
+		}
+	}
+	for _, mode := range slices.Sorted(maps.Keys(c.Routes)) {
+		if !render.ValidMode(mode) || mode == render.ModeAll {
+			errs = append(errs, fmt.Errorf("routes: cannot route mode %q (use receipt-only, qr-only, label or separator)", mode))
+		}
+		name := c.Routes[mode]
+		if _, ok := c.Printers[name]; !ok && name != "default" {
+			errs = append(errs, fmt.Errorf("routes.%s: no printer named %q in printers", mode, name))
+		}
+	}
+
+	if c.Columns() == 0 {
+		errs = append(errs, fmt.Errorf("paper_width: %d is not supported (use 57, 58 or 80)", c.PaperWidth))
+	}
+
+	if c.DefaultPrintMode != "" && !render.ValidMode(c.DefaultPrintMode) {
+		errs = append(errs, fmt.Errorf("default_print_mode: unknown mode %q", c.DefaultPrintMode))
+	}
+
+	if c.QueueDir == "" {
+		errs = append(errs, errors.New("queue_dir: a directory is required"))
+	}
+
+	if c.IdempotencyWindow < 0 {
+		errs = append(errs, errors.New("idempotency_window: must not be negative"))
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid config: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// BodyTokens returns the tokens accepted in the request body, or nil when
+// legacy_body_token is off
+func (c *Config) BodyTokens() []string {
+	if !c.LegacyBodyToken {
+		return nil
+	}
+	return c.Tokens
+}
+
+// ActiveKeys returns the number of API keys that are not revoked
+func (c *Config) ActiveKeys() int {
+	n := 0
+	for _, k := range c.APIKeys {
+		if !k.Revoked {
+			n++
+		}
+	}
+	return n
+}
+
+// IPAllowlist returns the client ranges served in LAN mode, or nil when
+// the agent only listens on loopback
+func (c *Config) IPAllowlist() []netip.Prefix {
+	if !c.LAN {
+		return nil
+	}
+	var prefixes []netip.Prefix
+	for _, s := range c.AllowedIPs {
+		if p, err := parsePrefix(s); err == nil {
+			prefixes = append(prefixes, p)
+		}
+	}
+	return prefixes
+}
+
+// LocalURL returns the agent's address for a browser on this PC
+func (c *Config) LocalURL() string {
+	return "http://localhost:" + port(c.Listen)
+}
+
+// LocalHTTPSURL returns the agent's HTTPS address for a browser on this
+// PC, or "" when HTTPS is off
+func (c *Config) LocalHTTPSURL() string {
+	if c.HTTPSListen == "" {
+		return ""
+	}
+	return "https://localhost:" + port(c.HTTPSListen)
+}
+
+// Origins returns the parsed allowed_origins
+func (c *Config) Origins() *cors.Allowlist {
+	origins, _ := cors.Parse(c.AllowedOrigins)
+	return origins
+}
+
+// AutoDetect reports whether the serial printer port is detected instead of configured
+func (c *Config) AutoDetect() bool {
+	serial := c.Printer.Type == transport.KindCOM || c.Printer.Type == transport.KindTTY
+	return serial && c.Printer.Device == ""
+}
+
+// Columns returns the characters per line for the paper width, or 0 if
+// the width is not supported
+func (c *Config) Columns() int {
+	switch c.PaperWidth {
+	case 57, 58:
+		return 32
+	case 80:
+		return 48
+	}
+	return 0
+}
+
+// RenderOptions returns the receipt layout for these settings
+func (c *Config) RenderOptions() render.Options {
+	opts := render.DefaultOptions
+	opts.Width = c.Columns()
+	return opts
+}
+
+// checkListen validates a listen address. Only loopback addresses are
+// allowed outside LAN mode.
+func (c *Config) checkListen(addr string) error {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return fmt.Errorf("invalid port %q", port)
+	}
+	if !c.LAN && !isLoopback(host) {
+		return fmt.Errorf("%s is reachable from other devices, set lan to true to allow this", addr)
+	}
+	return nil
+}
+
+func port(addr string) string {
+	_, port, _ := net.SplitHostPort(addr)
+	return port
+}
+
+// isLoopback reports whether a listen host only accepts connections from
+// this PC. An empty host listens on every interface.
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	addr, err := netip.ParseAddr(host)
+	return err == nil && addr.IsLoopback()
+}
+
+// parsePrefix parses "192.168.1.0/24" or a single address like
+// "192.168.1.20"
+func parsePrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		p, err := netip.ParsePrefix(s)
+		return p.Masked(), err
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+func splitList(s string) []string {
+	var out []string
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
@@ -0,0 +1,262 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"cleanlink/printer/render"
+	"cleanlink/printer/transport"
+)
+
+func writeConfig(t *testing.T, body string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "printer-config.json")
+	if err := os.WriteFile(path, []byte(body), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadDefaults(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	cfg, err := Load(Default(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Listen != "127.0.0.1:3491" || cfg.Tokens[0] != LegacyToken || !cfg.AutoDetect() || !cfg.StatusMonitor {
+		t.Errorf("unexpected defaults %+v", cfg)
+	}
+	if cfg.Path != "" {
+		t.Errorf("Path = %q without a config file", cfg.Path)
+	}
+	if got := cfg.RenderOptions().Width; got != 32 {
+		t.Errorf("width = %d, want 32", got)
+	}
+}
+
+func TestLoadLegacyFile(t *testing.T) {
+	path := writeConfig(t, `{"device_id": "RPP222"}`)
+
+	cfg, err := Load(Default(), []string{"-config", path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.DeviceID != "RPP222" || cfg.Path != path {
+		t.Errorf("DeviceID = %q, Path = %q", cfg.DeviceID, cfg.Path)
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	path := writeConfig(t, `{
+		"listen": ":4000",
+		"tokens": ["from-file"],
+		"printer": {"type": "tty", "device": "/dev/ttyUSB0", "baud_rate": 115200},
+		"paper_width": 80,
+		"default_print_mode": "receipt-only",
+		"status_monitor": false
+	}`)
+	t.Setenv("CLEANLINK_CONFIG", path)
+	t.Setenv("CLEANLINK_LISTEN", ":5000")
+	t.Setenv("CLEANLINK_TOKEN", "env-a, env-b")
+
+	cfg, err := Load(Default(), []string{"-listen", "127.0.0.1:6000", "-print-mode", "all"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.Listen != "127.0.0.1:6000" {
+		t.Errorf("Listen = %q, flag should win", cfg.Listen)
+	}
+	if strings.Join(cfg.Tokens, ",") != "env-a,env-b" {
+		t.Errorf("Tokens = %q, env should win", cfg.Tokens)
+	}
+	if cfg.DefaultPrintMode != render.ModeAll {
+		t.Errorf("DefaultPrintMode = %q", cfg.DefaultPrintMode)
+	}
+	if cfg.Printer.Type != transport.KindTTY || cfg.Printer.BaudRate != 115200 {
+		t.Errorf("Printer = %+v", cfg.Printer)
+	}
+	if got := cfg.RenderOptions().Width; got != 48 {
+		t.Errorf("width = %d, want 48", got)
+	}
+	if cfg.StatusMonitor {
+		t.Error("StatusMonitor = true, file should win over the default")
+	}
+}
+
+func TestLoadDoesNotModifyDefaults(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	defaults := Default()
+	if _, err := Load(defaults, []string{"-token", "other"}); err != nil {
+		t.Fatal(err)
+	}
+	if defaults.Tokens[0] != LegacyToken {
+		t.Errorf("defaults modified: %q", defaults.Tokens)
+	}
+}
+
+func TestLoadMissingExplicitFile(t *testing.T) {
+	_, err := Load(Default(), []string{"-config", filepath.Join(t.TempDir(), "nope.json")})
+	if err == nil {
+		t.Fatal("missing config file accepted")
+	}
+}
+
+func TestLoadUsage(t *testing.T) {
+	defer func(w io.Writer) { usageOutput = w }(usageOutput)
+	var out bytes.Buffer
+	usageOutput = &out
+
+	if _, err := Load(Default(), []string{"-h"}); !errors.Is(err, flag.ErrHelp) {
+		t.Errorf("-h: err = %v, want %v", err, flag.ErrHelp)
+	}
+	if !strings.Contains(out.String(), "-listen") {
+		t.Errorf("-h printed %q, want the flag list", out.String())
+	}
+
+	out.Reset()
+	if _, err := Load(Default(), []string{"-listn", "127.0.0.1:3491"}); err == nil {
+		t.Fatal("unknown flag accepted")
+	}
+	if !strings.Contains(out.String(), "-listen") {
+		t.Errorf("unknown flag printed %q, want the flag list", out.String())
+	}
+}
+
+func TestLoadBadJSON(t *testing.T) {
+	path := writeConfig(t, `{"listen": `)
+
+	if _, err := Load(Default(), []string{"-config", path}); err == nil {
+		t.Fatal("invalid JSON accepted")
+	}
+}
+
+func TestValidateAutoDetectSerialOptions(t *testing.T) {
+	// Line settings are checked even when the device is detected per job
+	path := writeConfig(t, `{"printer": {"type": "com", "parity": "bogus", "baud_rate": -5}}`)
+
+	_, err := Load(Default(), []string{"-config", path})
+	if err == nil || !strings.Contains(err.Error(), "printer") {
+		t.Fatalf("invalid serial options accepted: %v", err)
+	}
+}
+
+func TestValidateReportsEveryError(t *testing.T) {
+	path := writeConfig(t, `{
+		"listen": "no-port",
+		"legacy_body_token": true,
+		"tokens": [],
+		"api_keys": [{"name": "", "hash": "plain"}],
+		"allowed_origins": ["pos.cleanlink.com"],
+		"printer": {"type": "carrier-pigeon"},
+		"paper_width": 100,
+		"default_print_mode": "poster"
+	}`)
+
+	_, err := Load(Default(), []string{"-config", path})
+	if err == nil {
+		t.Fatal("invalid config accepted")
+	}
+	for _, field := range []string{"listen", "api_keys", "allowed_origins", "tokens", "printer", "paper_width", "default_print_mode"} {
+		if !strings.Contains(err.Error(), field) {
+			t.Errorf("error does not mention %s: %v", field, err)
+		}
+	}
+}
+
+func TestLANMode(t *testing.T) {
+	// Listening on every interface needs lan, an API key and allowed_ips
+	path := writeConfig(t, `{"listen": ":3491"}`)
+	if _, err := Load(Default(), []string{"-config", path}); err == nil || !strings.Contains(err.Error(), "lan") {
+		t.Fatalf("network listen address accepted without lan: %v", err)
+	}
+
+	_, err := Load(Default(), []string{"-config", path, "-lan"})
+	for _, field := range []string{"api_keys", "allowed_ips"} {
+		if err == nil || !strings.Contains(err.Error(), field) {
+			t.Errorf("lan without %s accepted: %v", field, err)
+		}
+	}
+
+	path = writeConfig(t, `{
+		"listen": "0.0.0.0:3491",
+		"lan": true,
+		"allowed_ips": ["192.168.1.0/24", "10.0.0.7"],
+		"api_keys": [{"name": "Tablet", "hash": "sha256:9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"}]
+	}`)
+	cfg, err := Load(Default(), []string{"-config", path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(cfg.IPAllowlist()); got != "[192.168.1.0/24 10.0.0.7/32]" {
+		t.Errorf("IPAllowlist() = %s", got)
+	}
+	if cfg.LocalURL() != "http://localhost:3491" {
+		t.Errorf("LocalURL() = %s", cfg.LocalURL())
+	}
+
+	if _, err := Load(Default(), []string{"-config", path, "-legacy-body-token"}); err == nil || !strings.Contains(err.Error(), "legacy_body_token") {
+		t.Errorf("legacy body token accepted in lan mode: %v", err)
+	}
+	if _, err := Load(Default(), []string{"-config", path, "-allowed-ips", "192.168.1.300"}); err == nil {
+		t.Error("invalid IP accepted")
+	}
+	if cfg, _ := Load(Default(), []string{"-listen", "localhost:3491"}); cfg == nil || cfg.IPAllowlist() != nil {
+		t.Error("loopback listen address has an IP filter")
+	}
+}
+
+func TestHTTPSListen(t *testing.T) {
+	cfg, err := Load(Default(), []string{"-https-listen", "127.0.0.1:3492"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.LocalHTTPSURL() != "https://localhost:3492" || cfg.CertDir != "tls" {
+		t.Errorf("LocalHTTPSURL() = %q, CertDir = %q", cfg.LocalHTTPSURL(), cfg.CertDir)
+	}
+
+	for _, addr := range []string{"127.0.0.1:3491", ":3492", "localhost"} {
+		if _, err := Load(Default(), []string{"-https-listen", addr}); err == nil || !strings.Contains(err.Error(), "https_listen") {
+			t.Errorf("https_listen %q accepted: %v", addr, err)
+		}
+	}
+
+	if cfg, _ := Load(Default(), nil); cfg.LocalHTTPSURL() != "" {
+		t.Error("HTTPS on by default")
+	}
+}
+
+func TestRoutes(t *testing.T) {
+	path := writeConfig(t, `{
+		"printers": {"labels": {"type": "tcp", "device": "192.168.1.50:9100"}},
+		"routes": {"receipt-only": "default", "qr-only": "labels"}
+	}`)
+	cfg, err := Load(Default(), []string{"-config", path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Printers["labels"].Device != "192.168.1.50:9100" || cfg.Routes[render.ModeQROnly] != "labels" {
+		t.Errorf("Printers = %+v, Routes = %v", cfg.Printers, cfg.Routes)
+	}
+
+	path = writeConfig(t, `{
+		"printers": {"default": {"type": "tcp", "device": "192.168.1.51"}, "kitchen": {"type": "com"}},
+		"routes": {"all": "default", "qr-only": "labels"}
+	}`)
+	_, err = Load(Default(), []string{"-config", path})
+	for _, want := range []string{`printers: "default"`, "printers.kitchen", `routes: cannot route mode "all"`, `routes.qr-only: no printer named "labels"`} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("error does not mention %s: %v", want, err)
+		}
+	}
+}
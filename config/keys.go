@@ -0,0 +1,222 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+
+	"cleanlink/printer/auth"
+)
+
+// KeyCommand runs the API key subcommands if args start with one:
+//
+//	new-key [-config file] <name>     add a key and print it once
+//	revoke-key [-config file] <name>  reject the key from now on
+//
+// The running agent picks the change up through hot reload. handled is
+// false when args are normal agent flags.
+func KeyCommand(args []string, out io.Writer) (handled bool, err error) {
+	if len(args) == 0 || (args[0] != "new-key" && args[0] != "revoke-key") {
+		return false, nil
+	}
+
+	fs := flag.NewFlagSet(args[0], flag.ContinueOnError)
+	fs.SetOutput(out)
+	path := fs.String("config", "", "path to the config file (default "+DefaultPath+")")
+	if err := fs.Parse(args[1:]); err != nil {
+		return true, err
+	}
+	if fs.NArg() != 1 {
+		return true, fmt.Errorf("usage: %s [-config file] <name>", args[0])
+	}
+	name := fs.Arg(0)
+
+	if *path == "" {
+		*path = os.Getenv("CLEANLINK_CONFIG")
+	}
+	if *path == "" {
+		*path = findDefault()
+	}
+	if *path == "" {
+		*path = DefaultPath
+	}
+
+	if args[0] == "new-key" {
+		secret, err := AddKey(*path, name)
+		if err != nil {
+			return true, err
+		}
+		fmt.Fprintf(out, "Added API key %q to %s\n\n    %s\n\n", name, *path, secret)
+		fmt.Fprintln(out, "Send it as \"Authorization: Bearer <key>\". It is not stored and cannot be shown again.")
+		return true, nil
+	}
+
+	if err := RevokeKey(*path, name); err != nil {
+		return true, err
+	}
+	fmt.Fprintf(out, "Revoked API key %q in %s\n", name, *path)
+	return true, nil
+}
+
+// AddKey generates a key named name, stores its hash in the config file at
+// path and returns the key. The file is created if it does not exist.
+func AddKey(path, name string) (string, error) {
+	secret, key, err := auth.Generate(name)
+	if err != nil {
+		return "", err
+	}
+	if err := key.Validate(); err != nil {
+		return "", err
+	}
+
+	err = editKeys(path, func(keys []auth.Key) ([]auth.Key, error) {
+		if slices.ContainsFunc(keys, func(k auth.Key) bool { return k.Name == name }) {
+			return nil, fmt.Errorf("an API key named %q already exists", name)
+		}
+		return append(keys, key), nil
+	})
+	return secret, err
+}
+
+// RevokeKey marks the key named name as revoked in the config file at path
+func RevokeKey(path, name string) error {
+	return editKeys(path, func(keys []auth.Key) ([]auth.Key, error) {
+		i := slices.IndexFunc(keys, func(k auth.Key) bool { return k.Name == name })
+		if i < 0 {
+			return nil, fmt.Errorf("no API key named %q", name)
+		}
+		keys[i].Revoked = true
+		return keys, nil
+	})
+}
+
+// editKeys rewrites api_keys in the config file and leaves every other
+// setting as it is
+func editKeys(path string, edit func([]auth.Key) ([]auth.Key, error)) error {
+	return editFile(path, func(fields *object) error {
+		var keys []auth.Key
+		if raw, ok := fields.get("api_keys"); ok {
+			if err := json.Unmarshal(raw, &keys); err != nil {
+				return fmt.Errorf("parse %s: api_keys: %w", path, err)
+			}
+		}
+
+		keys, err := edit(keys)
+		if err != nil {
+			return err
+		}
+		raw, err := json.Marshal(keys)
+		if err != nil {
+			return err
+		}
+		fields.set("api_keys", raw)
+		return nil
+	})
+}
+
+// editFile rewrites the top-level settings of the config file at path that
+// edit changes. The other settings and the order of all of them are kept.
+// The file is created if it does not exist.
+func editFile(path string, edit func(fields *object) error) error {
+	fields := &object{}
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return fmt.Errorf("read config: %w", err)
+	default:
+		if err := json.Unmarshal(data, fields); err != nil {
+			return fmt.Errorf("parse %s: %w", path, err)
+		}
+	}
+
+	if err := edit(fields); err != nil {
+		return err
+	}
+
+	data, err = json.MarshalIndent(fields, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// object is a JSON object that keeps the order of its keys, so a config
+// file edited by the agent keeps the layout the user gave it
+type object struct {
+	keys   []string
+	values map[string]json.RawMessage
+}
+
+func (o *object) get(name string) (json.RawMessage, bool) {
+	v, ok := o.values[name]
+	return v, ok
+}
+
+// set replaces the value of name in place, or adds it at the end
+func (o *object) set(name string, v json.RawMessage) {
+	if o.values == nil {
+		o.values = map[string]json.RawMessage{}
+	}
+	if _, ok := o.values[name]; !ok {
+		o.keys = append(o.keys, name)
+	}
+	o.values[name] = v
+}
+
+func (o *object) remove(name string) {
+	if _, ok := o.values[name]; !ok {
+		return
+	}
+	delete(o.values, name)
+	o.keys = slices.DeleteFunc(o.keys, func(k string) bool { return k == name })
+}
+
+func (o *object) UnmarshalJSON(data []byte) error {
+	*o = object{}
+	if string(bytes.TrimSpace(data)) == "null" {
+		return nil
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return errors.New("not a JSON object")
+	}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		var v json.RawMessage
+		if err := dec.Decode(&v); err != nil {
+			return err
+		}
+		o.set(tok.(string), v)
+	}
+	_, err := dec.Token()
+	return err
+}
+
+func (o *object) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, name := range o.keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		key, err := json.Marshal(name)
+		if err != nil {
+			return nil, err
+		}
+		b.Write(key)
+		b.WriteByte(':')
+		b.Write(o.values[name])
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
@@ -0,0 +1,118 @@
+package config
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"cleanlink/printer/auth"
+	"cleanlink/printer/transport"
+)
+
+func TestKeyCommands(t *testing.T) {
+	path := writeConfig(t, `{"device_id": "RPP02N"}`)
+
+	var out bytes.Buffer
+	handled, err := KeyCommand([]string{"new-key", "-config", path, "Kasir 1"}, &out)
+	if !handled || err != nil {
+		t.Fatalf("new-key: handled = %v, err = %v", handled, err)
+	}
+	secret := strings.Fields(strings.SplitN(out.String(), "\n\n", 3)[1])[0]
+
+	data, _ := os.ReadFile(path)
+	if strings.Contains(string(data), secret) {
+		t.Error("key written to the config file in plain text")
+	}
+
+	cfg, err := Load(Default(), []string{"-config", path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.DeviceID != "RPP02N" {
+		t.Error("other settings lost")
+	}
+	if k, ok := auth.Match(cfg.APIKeys, secret); !ok || k.Name != "Kasir 1" {
+		t.Errorf("printed key does not match the stored hash: %+v", cfg.APIKeys)
+	}
+
+	if _, err := KeyCommand([]string{"new-key", "-config", path, "Kasir 1"}, &out); err == nil {
+		t.Error("duplicate name accepted")
+	}
+
+	if _, err := KeyCommand([]string{"revoke-key", "-config", path, "Kasir 1"}, &out); err != nil {
+		t.Fatal(err)
+	}
+	cfg, _ = Load(Default(), []string{"-config", path})
+	if _, ok := auth.Match(cfg.APIKeys, secret); ok || cfg.ActiveKeys() != 0 {
+		t.Error("revoked key still accepted")
+	}
+
+	if _, err := KeyCommand([]string{"revoke-key", "-config", path, "Kasir 9"}, &out); err == nil {
+		t.Error("unknown key revoked")
+	}
+}
+
+func TestKeyCommandCreatesConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), DefaultPath)
+
+	if _, err := KeyCommand([]string{"new-key", "-config", path, "Cabang Utama"}, &bytes.Buffer{}); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := Load(Default(), []string{"-config", path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.ActiveKeys() != 1 {
+		t.Errorf("APIKeys = %+v", cfg.APIKeys)
+	}
+}
+
+func TestKeyCommandIgnoresAgentFlags(t *testing.T) {
+	if handled, _ := KeyCommand([]string{"-listen", ":9000"}, &bytes.Buffer{}); handled {
+		t.Error("agent flags treated as a key command")
+	}
+}
+
+func TestBodyTokens(t *testing.T) {
+	cfg := Default()
+	if cfg.BodyTokens() != nil {
+		t.Error("body token accepted by default")
+	}
+	cfg.LegacyBodyToken = true
+	if got := cfg.BodyTokens(); len(got) != 1 || got[0] != LegacyToken {
+		t.Errorf("BodyTokens() = %q", got)
+	}
+}
+
+func TestEditKeepsOrder(t *testing.T) {
+	path := writeConfig(t, `{
+  "listen": "127.0.0.1:3491",
+  "printer": {"type": "com", "device": "COM10", "baud_rate": 115200},
+  "device_id": "RPP02N",
+  "api_keys": [],
+  "allowed_origins": ["https://pos.cleanlink.com"]
+}`)
+
+	if _, err := AddKey(path, "Kasir 1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := SavePrinter(path, transport.Config{Type: transport.KindCOM, Device: "COM12", Fingerprint: "bt:00:11:22:AA:BB:CC"}); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	order := []string{`"listen"`, `"printer"`, `"type"`, `"device"`, `"baud_rate"`, `"fingerprint"`, `"device_id"`, `"api_keys"`, `"allowed_origins"`}
+	last := -1
+	for _, key := range order {
+		i := strings.Index(string(data), key)
+		if i < last {
+			t.Fatalf("%s moved, file is now:\n%s", key, data)
+		}
+		last = i
+	}
+}
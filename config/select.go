@@ -0,0 +1,48 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"cleanlink/printer/transport"
+)
+
+// SavePrinter stores the type, device and fingerprint of a printer the
+// user selected in the config file at path, leaving its other printer
+// settings as they are. The running agent picks the change up through hot
+// reload.
+func SavePrinter(path string, pc transport.Config) error {
+	return editFile(path, func(fields *object) error {
+		printer := &object{}
+		if raw, ok := fields.get("printer"); ok {
+			if err := json.Unmarshal(raw, printer); err != nil {
+				return fmt.Errorf("parse %s: printer: %w", path, err)
+			}
+		}
+
+		for _, f := range []struct{ name, value string }{{"type", pc.Type}, {"device", pc.Device}, {"fingerprint", pc.Fingerprint}} {
+			if f.value == "" {
+				printer.remove(f.name)
+				continue
+			}
+			v, _ := json.Marshal(f.value)
+			printer.set(f.name, v)
+		}
+
+		raw, err := json.Marshal(printer)
+		if err != nil {
+			return err
+		}
+		fields.set("printer", raw)
+		return nil
+	})
+}
+
+// SavePath returns the config file settings are saved to: the file they
+// were loaded from, else the default one
+func (c *Config) SavePath() string {
+	if c.Path != "" {
+		return c.Path
+	}
+	return DefaultPath
+}
@@ -0,0 +1,27 @@
+package config
+
+import (
+	"testing"
+
+	"cleanlink/printer/transport"
+)
+
+func TestSavePrinter(t *testing.T) {
+	path := writeConfig(t, `{"device_id": "RPP02N", "printer": {"type": "com", "device": "COM10", "baud_rate": 115200}}`)
+
+	pc := transport.Config{Type: transport.KindCOM, Device: "COM12", Fingerprint: "bt:00:11:22:AA:BB:CC"}
+	if err := SavePrinter(path, pc); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := Load(Default(), []string{"-config", path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Printer.Device != "COM12" || cfg.Printer.Fingerprint != pc.Fingerprint {
+		t.Errorf("Printer = %+v", cfg.Printer)
+	}
+	if cfg.Printer.BaudRate != 115200 || cfg.DeviceID != "RPP02N" {
+		t.Error("other settings lost")
+	}
+}
@@ -0,0 +1,277 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	"cleanlink/printer/transport"
+)
+
+// reloadDelay lets editors finish writing (truncate, write, rename) before
+// the file is read again
+const reloadDelay = 250 * time.Millisecond
+
+// Store holds the current settings and swaps them atomically when the
+// config file changes
+type Store struct {
+	defaults Config
+	args     []string
+	current  atomic.Pointer[Config]
+}
+
+// NewStore loads the settings like Load and keeps what is needed to load
+// them again
+func NewStore(defaults Config, args []string) (*Store, error) {
+	cfg, err := Load(defaults, args)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Store{defaults: defaults, args: args}
+	s.current.Store(cfg)
+	return s, nil
+}
+
+// Get returns the current settings. The returned Config must not be
+// modified.
+func (s *Store) Get() *Config {
+	return s.current.Load()
+}
+
+// Reload reads and validates the settings again. On success the new
+// settings replace the current ones and the differences are returned; on
+// error the current settings are kept.
+func (s *Store) Reload() (*Config, []string, error) {
+	cfg, changes, err := s.load()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	s.current.Store(cfg)
+	return cfg, changes, nil
+}
+
+// load reads and validates the settings again without replacing the
+// current ones, and returns them with the differences.
+//
+// The listen addresses, lan and queue_dir keep their running values until
+// a restart, since the listeners stay bound where they were. The new
+// settings are validated against them, so switching lan off while the agent
+// still listens on the network cannot drop the IP filter or the API key
+// requirement.
+func (s *Store) load() (*Config, []string, error) {
+	cfg, err := Load(s.defaults, s.args)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	old := s.Get()
+	changes := Diff(old, cfg)
+	cfg.Listen, cfg.HTTPSListen, cfg.CertDir = old.Listen, old.HTTPSListen, old.CertDir
+	cfg.LAN, cfg.QueueDir = old.LAN, old.QueueDir
+	if err := cfg.Validate(); err != nil {
+		return nil, nil, fmt.Errorf("%w (lan and listen take effect after restart)", err)
+	}
+	return cfg, changes, nil
+}
+
+// Watch reloads the settings whenever the config file changes and passes
+// them to apply, until ctx is cancelled. They replace the current settings
+// only once apply accepted them. Invalid files and settings apply rejects
+// are logged and ignored so a typo never takes a running agent down.
+func (s *Store) Watch(ctx context.Context, apply func(*Config) error) error {
+	path := s.Get().Path
+	if path == "" {
+		// Pick up a config file created after startup
+		path = DefaultPath
+	}
+	path = filepath.Clean(path)
+
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	// Watch the directory, editors often replace the file instead of
+	// writing to it
+	if err := w.Add(filepath.Dir(path)); err != nil {
+		return err
+	}
+
+	reload := make(chan struct{}, 1)
+	timer := time.AfterFunc(time.Hour, func() {
+		select {
+		case reload <- struct{}{}:
+		default:
+		}
+	})
+	timer.Stop()
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case ev, ok := <-w.Events:
+			if !ok {
+				return nil
+			}
+			if filepath.Clean(ev.Name) == path && ev.Has(fsnotify.Write|fsnotify.Create) {
+				timer.Reset(reloadDelay)
+			}
+		case err, ok := <-w.Errors:
+			if !ok {
+				return nil
+			}
+			fmt.Println("[CONFIG] Watch error:", err)
+		case <-reload:
+			cfg, changes, err := s.load()
+			if err != nil {
+				fmt.Println("[CONFIG] Reload failed, keeping current settings:", err)
+				continue
+			}
+			if len(changes) == 0 {
+				continue
+			}
+			if err := apply(cfg); err != nil {
+				fmt.Println("[CONFIG] Not applied, keeping current settings:", err)
+				continue
+			}
+			s.current.Store(cfg)
+			for _, c := range changes {
+				fmt.Println("[CONFIG] Changed", c)
+			}
+		}
+	}
+}
+
+// Diff describes what changed between two configs. Token values are never
+// included.
+func Diff(old, cfg *Config) []string {
+	var changes []string
+	if old.Listen != cfg.Listen {
+		changes = append(changes, fmt.Sprintf("listen: %s -> %s (takes effect after restart)", old.Listen, cfg.Listen))
+	}
+	if old.HTTPSListen != cfg.HTTPSListen || old.CertDir != cfg.CertDir {
+		changes = append(changes, fmt.Sprintf("https_listen: %q (%s) -> %q (%s) (takes effect after restart)", old.HTTPSListen, old.CertDir, cfg.HTTPSListen, cfg.CertDir))
+	}
+	if old.LAN != cfg.LAN {
+		changes = append(changes, fmt.Sprintf("lan: %t -> %t (takes effect after restart)", old.LAN, cfg.LAN))
+	}
+	if !slices.Equal(old.AllowedIPs, cfg.AllowedIPs) {
+		changes = append(changes, fmt.Sprintf("allowed_ips: %q -> %q", old.AllowedIPs, cfg.AllowedIPs))
+	}
+	if !slices.Equal(old.APIKeys, cfg.APIKeys) {
+		changes = append(changes, fmt.Sprintf("api_keys: %d -> %d active", old.ActiveKeys(), cfg.ActiveKeys()))
+	}
+	if old.LegacyBodyToken != cfg.LegacyBodyToken {
+		changes = append(changes, fmt.Sprintf("legacy_body_token: %t -> %t", old.LegacyBodyToken, cfg.LegacyBodyToken))
+	}
+	if !slices.Equal(old.Tokens, cfg.Tokens) {
+		changes = append(changes, fmt.Sprintf("tokens: %d -> %d configured", len(old.Tokens), len(cfg.Tokens)))
+	}
+	if !slices.Equal(old.AllowedOrigins, cfg.AllowedOrigins) {
+		changes = append(changes, fmt.Sprintf("allowed_origins: %q -> %q", old.AllowedOrigins, cfg.AllowedOrigins))
+	}
+	if (old.SigningSecret != "") != (cfg.SigningSecret != "") {
+		changes = append(changes, fmt.Sprintf("signing: %s -> %s", onOff(old.SigningSecret != ""), onOff(cfg.SigningSecret != "")))
+	} else if old.SigningSecret != cfg.SigningSecret {
+		changes = append(changes, "signing_secret: changed")
+	}
+	if old.SigningWindow != cfg.SigningWindow {
+		changes = append(changes, fmt.Sprintf("signing_window: %s -> %s", time.Duration(old.SigningWindow), time.Duration(cfg.SigningWindow)))
+	}
+	if old.DeviceID != cfg.DeviceID {
+		changes = append(changes, fmt.Sprintf("device_id: %q -> %q", old.DeviceID, cfg.DeviceID))
+	}
+	if a, b := printerJSON(old), printerJSON(cfg); a != b {
+		changes = append(changes, fmt.Sprintf("printer: %s -> %s", describePrinter(old), describePrinter(cfg)))
+	}
+	if a, b := printersJSON(old), printersJSON(cfg); a != b {
+		changes = append(changes, fmt.Sprintf("printers: %s -> %s", describePrinters(old), describePrinters(cfg)))
+	}
+	if !maps.Equal(old.Routes, cfg.Routes) {
+		changes = append(changes, fmt.Sprintf("routes: %s -> %s", describeRoutes(old), describeRoutes(cfg)))
+	}
+	if old.PaperWidth != cfg.PaperWidth {
+		changes = append(changes, fmt.Sprintf("paper_width: %d -> %d", old.PaperWidth, cfg.PaperWidth))
+	}
+	if old.DefaultPrintMode != cfg.DefaultPrintMode {
+		changes = append(changes, fmt.Sprintf("default_print_mode: %q -> %q", old.DefaultPrintMode, cfg.DefaultPrintMode))
+	}
+	if old.IdempotencyWindow != cfg.IdempotencyWindow {
+		changes = append(changes, fmt.Sprintf("idempotency_window: %s -> %s", time.Duration(old.IdempotencyWindow), time.Duration(cfg.IdempotencyWindow)))
+	}
+	if old.StatusMonitor != cfg.StatusMonitor {
+		changes = append(changes, fmt.Sprintf("status_monitor: %s -> %s", onOff(old.StatusMonitor), onOff(cfg.StatusMonitor)))
+	}
+	if old.QueueDir != cfg.QueueDir {
+		changes = append(changes, fmt.Sprintf("queue_dir: %s -> %s (takes effect after restart)", old.QueueDir, cfg.QueueDir))
+	}
+	return changes
+}
+
+func onOff(b bool) string {
+	if b {
+		return "on"
+	}
+	return "off"
+}
+
+func printerJSON(c *Config) string {
+	data, _ := json.Marshal(c.Printer)
+	return string(data)
+}
+
+func describePrinter(c *Config) string {
+	s := c.Printer.Type + " " + c.Printer.Device
+	if c.AutoDetect() {
+		s = c.Printer.Type + " auto-detect"
+	}
+	if c.Printer.Fingerprint != "" {
+		s += " " + c.Printer.Fingerprint
+	}
+	switch c.Printer.Type {
+	case transport.KindCOM, transport.KindTTY:
+		s += " (" + c.Printer.SerialOptions.String() + ")"
+	}
+	return s
+}
+
+func printersJSON(c *Config) string {
+	data, _ := json.Marshal(c.Printers)
+	return string(data)
+}
+
+func describePrinters(c *Config) string {
+	if len(c.Printers) == 0 {
+		return "none"
+	}
+	var printers []string
+	for _, name := range slices.Sorted(maps.Keys(c.Printers)) {
+		pc := c.Printers[name]
+		printers = append(printers, fmt.Sprintf("%s (%s %s)", name, pc.Type, pc.Device))
+	}
+	return strings.Join(printers, ", ")
+}
+
+func describeRoutes(c *Config) string {
+	if len(c.Routes) == 0 {
+		return "none"
+	}
+	var routes []string
+	for _, mode := range slices.Sorted(maps.Keys(c.Routes)) {
+		routes = append(routes, mode+"="+c.Routes[mode])
+	}
+	return strings.Join(routes, ", ")
+}
@@ -0,0 +1,205 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStoreReload(t *testing.T) {
+	path := writeConfig(t, `{"printer": {"type": "com", "device": "COM10"}}`)
+
+	s, err := NewStore(Default(), []string{"-config", path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := s.Get()
+
+	os.WriteFile(path, []byte(`{"printer": {"type": "com", "device": "COM5"}, "tokens": ["a", "b"]}`), 0644)
+	cfg, changes, err := s.Reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Get() != cfg || cfg.Printer.Device != "COM5" {
+		t.Errorf("settings not swapped: %+v", s.Get().Printer)
+	}
+	if old.Printer.Device != "COM10" {
+		t.Error("old settings were modified")
+	}
+
+	joined := strings.Join(changes, "\n")
+	if !strings.Contains(joined, "COM10") || !strings.Contains(joined, "COM5") || !strings.Contains(joined, "tokens: 1 -> 2") {
+		t.Errorf("changes = %q", changes)
+	}
+	if strings.Contains(joined, LegacyToken) {
+		t.Error("token value logged")
+	}
+
+	// An invalid file keeps the current settings
+	os.WriteFile(path, []byte(`{"paper_width": 1}`), 0644)
+	if _, _, err := s.Reload(); err == nil {
+		t.Fatal("invalid config accepted")
+	}
+	if s.Get() != cfg {
+		t.Error("settings replaced by invalid config")
+	}
+}
+
+func TestDiffUnchanged(t *testing.T) {
+	a, b := Default(), Default()
+	retries := 3
+	a.Printer.Retries, b.Printer.Retries = &retries, &retries
+
+	if changes := Diff(&a, &b); len(changes) != 0 {
+		t.Errorf("Diff of equal configs = %q", changes)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	path := writeConfig(t, `{"device_id": "RPP222"}`)
+
+	s, err := NewStore(Default(), []string{"-config", path})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	applied := make(chan *Config, 1)
+	done := make(chan error, 1)
+	go func() {
+		done <- s.Watch(ctx, func(cfg *Config) error {
+			applied <- cfg
+			return nil
+		})
+	}()
+
+	// Give the watcher time to start, then replace the file like an editor
+	time.Sleep(100 * time.Millisecond)
+	tmp := path + ".tmp"
+	os.WriteFile(tmp, []byte(`{"device_id": "RPP02N"}`), 0644)
+	if err := os.Rename(tmp, path); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case cfg := <-applied:
+		if cfg.DeviceID != "RPP02N" {
+			t.Errorf("DeviceID = %q", cfg.DeviceID)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("config change not applied")
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWatchKeepsRejected(t *testing.T) {
+	path := writeConfig(t, `{"device_id": "RPP222"}`)
+
+	s, err := NewStore(Default(), []string{"-config", path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	running := s.Get()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	applied := make(chan *Config, 1)
+	done := make(chan error, 1)
+	// The first change is rejected, the second accepted
+	answers := make(chan error, 2)
+	answers <- errors.New("printer not found")
+	answers <- nil
+	go func() {
+		done <- s.Watch(ctx, func(cfg *Config) error {
+			applied <- cfg
+			return <-answers
+		})
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	os.WriteFile(path, []byte(`{"device_id": "RPP02N"}`), 0644)
+	select {
+	case <-applied:
+	case <-time.After(5 * time.Second):
+		t.Fatal("config change not passed to apply")
+	}
+	// Watch stores the settings after apply returns
+	time.Sleep(50 * time.Millisecond)
+	if s.Get() != running {
+		t.Fatal("rejected settings replaced the running ones")
+	}
+
+	// The next change is compared with the settings still running
+	os.WriteFile(path, []byte(`{"device_id": "RPP02N", "paper_width": 80}`), 0644)
+	select {
+	case cfg := <-applied:
+		if cfg.DeviceID != "RPP02N" {
+			t.Errorf("DeviceID = %q", cfg.DeviceID)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("config change not passed to apply")
+	}
+	deadline := time.Now().Add(time.Second)
+	for s.Get().PaperWidth != 80 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := s.Get(); got.DeviceID != "RPP02N" || got.PaperWidth != 80 {
+		t.Errorf("settings = %q, %d", got.DeviceID, got.PaperWidth)
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReloadKeepsLAN(t *testing.T) {
+	path := writeConfig(t, `{
+		"listen": "0.0.0.0:3491",
+		"lan": true,
+		"allowed_ips": ["192.168.1.0/24"],
+		"api_keys": [{"name": "Tablet", "hash": "sha256:9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"}]
+	}`)
+	s, err := NewStore(Default(), []string{"-config", path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	running := s.Get()
+
+	// Turning lan off does not drop the IP filter or the key requirement
+	// while the agent still listens on 0.0.0.0
+	os.WriteFile(path, []byte(`{"listen": "127.0.0.1:3491", "lan": false}`), 0644)
+	if _, _, err := s.Reload(); err == nil || !strings.Contains(err.Error(), "api_keys") {
+		t.Fatalf("reload without keys accepted while listening on the network: %v", err)
+	}
+	if s.Get() != running {
+		t.Error("settings replaced")
+	}
+
+	os.WriteFile(path, []byte(`{
+		"listen": "127.0.0.1:3491",
+		"lan": false,
+		"allowed_ips": ["192.168.1.0/24", "10.0.0.7"],
+		"api_keys": [{"name": "Tablet", "hash": "sha256:9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"}]
+	}`), 0644)
+	cfg, changes, err := s.Reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cfg.LAN || cfg.Listen != "0.0.0.0:3491" || len(cfg.IPAllowlist()) != 2 {
+		t.Errorf("lan = %t, listen = %s, allowlist = %v", cfg.LAN, cfg.Listen, cfg.IPAllowlist())
+	}
+	if joined := strings.Join(changes, "\n"); !strings.Contains(joined, "lan: true -> false (takes effect after restart)") {
+		t.Errorf("changes = %q", changes)
+	}
+}
@@ -0,0 +1,112 @@
+// Package cors decides which web origins may call the agent from a
+// browser. Patterns are exact origins such as "https://pos.cleanlink.com"
+// or wildcard subdomains such as "https://*.cleanlink.com".
+package cors
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Allowlist is a parsed set of origin patterns. The zero value and nil
+// allow nothing.
+type Allowlist struct {
+	any      bool
+	exact    map[string]bool
+	suffixes []origin // Host holds ".cleanlink.com" for "*.cleanlink.com"
+}
+
+type origin struct {
+	Scheme, Host, Port string
+}
+
+// Parse parses origin patterns. "*" allows every origin.
+func Parse(patterns []string) (*Allowlist, error) {
+	a := &Allowlist{exact: map[string]bool{}}
+	var errs []error
+	for _, p := range patterns {
+		if p == "*" {
+			a.any = true
+			continue
+		}
+
+		o, err := parseOrigin(p)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%q: %w", p, err))
+			continue
+		}
+		if suffix, ok := strings.CutPrefix(o.Host, "*."); ok {
+			if suffix == "" || strings.Contains(suffix, "*") {
+				errs = append(errs, fmt.Errorf("%q: wildcard must be followed by a domain", p))
+				continue
+			}
+			o.Host = "." + suffix
+			a.suffixes = append(a.suffixes, o)
+			continue
+		}
+		if strings.Contains(o.Host, "*") {
+			errs = append(errs, fmt.Errorf("%q: a wildcard is only allowed as the first label", p))
+			continue
+		}
+		a.exact[o.String()] = true
+	}
+	return a, errors.Join(errs...)
+}
+
+// Allowed reports whether a request with this Origin header may be served
+func (a *Allowlist) Allowed(value string) bool {
+	if a == nil || value == "" {
+		return false
+	}
+	if a.any {
+		return true
+	}
+
+	o, err := parseOrigin(value)
+	if err != nil || strings.Contains(o.Host, "*") {
+		return false
+	}
+	if a.exact[o.String()] {
+		return true
+	}
+	for _, w := range a.suffixes {
+		if o.Scheme == w.Scheme && o.Port == w.Port && strings.HasSuffix(o.Host, w.Host) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseOrigin splits "scheme://host[:port]" and lower-cases it. Default
+// ports are dropped so "https://a.com:443" equals "https://a.com".
+func parseOrigin(s string) (origin, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return origin{}, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return origin{}, errors.New("scheme must be http or https")
+	}
+	if u.Hostname() == "" {
+		return origin{}, errors.New("host is required")
+	}
+	if (u.Path != "" && u.Path != "/") || u.RawQuery != "" || u.Fragment != "" || u.User != nil {
+		return origin{}, errors.New("must be only scheme://host[:port]")
+	}
+
+	o := origin{Scheme: u.Scheme, Host: strings.ToLower(u.Hostname()), Port: u.Port()}
+	if (o.Scheme == "http" && o.Port == "80") || (o.Scheme == "https" && o.Port == "443") {
+		o.Port = ""
+	}
+	return o, nil
+}
+
+func (o origin) String() string {
+	s := o.Scheme + "://" + o.Host
+	if o.Port != "" {
+		s += ":" + o.Port
+	}
+	return s
+}
@@ -0,0 +1,63 @@
+package cors
+
+import "testing"
+
+func TestAllowed(t *testing.T) {
+	a, err := Parse([]string{
+		"https://pos.cleanlink.com",
+		"https://*.cleanlink.id",
+		"http://localhost:5173",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for origin, want := range map[string]bool{
+		"https://pos.cleanlink.com":      true,
+		"https://POS.cleanlink.com":      true,
+		"https://pos.cleanlink.com:443":  true,
+		"http://pos.cleanlink.com":       false,
+		"https://pos.cleanlink.com:8443": false,
+		"https://evil.com":               false,
+		"https://pos.cleanlink.com.evil": false,
+		"https://branch1.cleanlink.id":   true,
+		"https://a.b.cleanlink.id":       true,
+		"https://cleanlink.id":           false,
+		"https://evilcleanlink.id":       false,
+		"http://localhost:5173":          true,
+		"http://localhost:3000":          false,
+		"null":                           false,
+		"":                               false,
+	} {
+		if got := a.Allowed(origin); got != want {
+			t.Errorf("Allowed(%q) = %v, want %v", origin, got, want)
+		}
+	}
+}
+
+func TestAny(t *testing.T) {
+	a, _ := Parse([]string{"*"})
+	if !a.Allowed("https://anything.example") {
+		t.Error("* does not allow every origin")
+	}
+
+	var none *Allowlist
+	if none.Allowed("https://pos.cleanlink.com") {
+		t.Error("nil allowlist allows an origin")
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, p := range []string{
+		"pos.cleanlink.com",
+		"ftp://pos.cleanlink.com",
+		"https://pos.cleanlink.com/app",
+		"https://*",
+		"https://pos.*.com",
+		"https://",
+	} {
+		if _, err := Parse([]string{p}); err == nil {
+			t.Errorf("Parse(%q) accepted", p)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package discovery
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// CIM queries run through PowerShell. Serial ports come first because
+// Win32_SerialPort knows their COM name; Win32_PnPEntity adds the ports it
+// misses, such as some Bluetooth links, and USB printer class devices.
+var cimQueries = []string{
+	`Get-CimInstance Win32_SerialPort | Select-Object Name, DeviceID, PNPDeviceID | ConvertTo-Json`,
+	`Get-CimInstance Win32_PnPEntity -Filter "PNPClass = 'Ports' OR Service = 'usbprint'" | Select-Object Name, PNPDeviceID, Service | ConvertTo-Json`,
+}
+
+// cimTimeout limits each PowerShell query
+const cimTimeout = 10 * time.Second
+
+// CIM finds Windows serial ports and USB printers in the CIM (WMI) classes
+type CIM struct {
+	// Run runs a PowerShell command and returns its output. Nil runs
+	// powershell.exe; tests return captured output instead.
+	Run func(ctx context.Context, command string) ([]byte, error)
+}
+
+func (CIM) Name() string { return "cim" }
+
+func (p CIM) Candidates(ctx context.Context) ([]Candidate, error) {
+	run := p.Run
+	if run == nil {
+		run = powershell
+	}
+
+	var candidates []Candidate
+	var errs []error
+	for _, query := range cimQueries {
+		out, err := run(ctx, query)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		objects, err := parseCIM(out)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for _, o := range objects {
+			candidates = append(candidates, o.candidate())
+		}
+	}
+	return candidates, errors.Join(errs...)
+}
+
+// cimObject is one object of ConvertTo-Json output
+type cimObject struct {
+	Name        string
+	DeviceID    string
+	PNPDeviceID string
+	Service     string
+}
+
+// comName matches the port at the end of a PnP name, e.g.
+// "Standard Serial over Bluetooth link (COM10)"
+var comName = regexp.MustCompile(`\((COM\d+)\)$`)
+
+func (o cimObject) candidate() Candidate {
+	c := Candidate{
+		PrinterInfo: PrinterInfo{Name: o.Name, DeviceID: o.DeviceID, PNPDeviceID: o.PNPDeviceID},
+		Service:     o.Service,
+	}
+	if o.DeviceID == "" || strings.EqualFold(o.DeviceID, o.PNPDeviceID) {
+		// Win32_PnPEntity has no COM name of its own
+		c.DeviceID = ""
+		if m := comName.FindStringSubmatch(strings.TrimSpace(o.Name)); m != nil {
+			c.DeviceID = m[1]
+		}
+	}
+	return c
+}
+
+// parseCIM decodes ConvertTo-Json output, which is nothing for no objects,
+// a single object for one and an array for more
+func parseCIM(out []byte) ([]cimObject, error) {
+	out = bytes.TrimSpace(bytes.TrimPrefix(out, []byte("\xef\xbb\xbf")))
+	if len(out) == 0 {
+		return nil, nil
+	}
+
+	var objects []cimObject
+	var err error
+	if out[0] == '[' {
+		err = json.Unmarshal(out, &objects)
+	} else {
+		var o cimObject
+		err = json.Unmarshal(out, &o)
+		objects = append(objects, o)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse CIM output: %w", err)
+	}
+	return objects, nil
+}
+
+// powershell runs command in Windows PowerShell
+func powershell(ctx context.Context, command string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, cimTimeout)
+	defer cancel()
+
+	out, err := exec.CommandContext(ctx, "powershell", "-NoProfile", "-NonInteractive", "-Command", command).Output()
+	if err != nil {
+		var exit *exec.ExitError
+		if errors.As(err, &exit) && len(exit.Stderr) > 0 {
+			return nil, fmt.Errorf("powershell: %w: %s", err, bytes.TrimSpace(exit.Stderr))
+		}
+		return nil, fmt.Errorf("powershell: %w", err)
+	}
+	return out, nil
+}
@@ -0,0 +1,117 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+// capturedCIM returns a CIM provider that answers the queries with output
+// captured from Windows 10 PowerShell 5.1
+func capturedCIM(t *testing.T, files ...string) CIM {
+	t.Helper()
+	outputs := map[string][]byte{}
+	for i, name := range files {
+		if name == "" {
+			continue
+		}
+		data, err := os.ReadFile("testdata/" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		outputs[cimQueries[i]] = data
+	}
+	return CIM{Run: func(_ context.Context, command string) ([]byte, error) {
+		data, ok := outputs[command]
+		if !ok {
+			return nil, errors.New("Get-CimInstance : Access denied")
+		}
+		return data, nil
+	}}
+}
+
+func TestParseCIM(t *testing.T) {
+	data, _ := os.ReadFile("testdata/win32_serialport.json")
+	objects, err := parseCIM(data)
+	if err != nil || len(objects) != 4 {
+		t.Fatalf("parseCIM(array) = %d objects, %v", len(objects), err)
+	}
+	if o := objects[2]; o.DeviceID != "COM10" || !strings.Contains(o.PNPDeviceID, `_LOCALMFG&0002\7&`) {
+		t.Errorf("unexpected object %+v", o)
+	}
+
+	// A single object, with the BOM and CRLF line ends of PowerShell
+	data, _ = os.ReadFile("testdata/win32_serialport_single.json")
+	objects, err = parseCIM(data)
+	if err != nil || len(objects) != 1 || objects[0].DeviceID != "COM10" {
+		t.Errorf("parseCIM(single) = %+v, %v", objects, err)
+	}
+
+	// No objects at all
+	if objects, err := parseCIM([]byte("\r\n")); err != nil || objects != nil {
+		t.Errorf("parseCIM(empty) = %+v, %v", objects, err)
+	}
+
+	if _, err := parseCIM([]byte("Get-CimInstance : Invalid class \"Win32_SerialPort\"")); err == nil {
+		t.Error("parseCIM accepted an error message")
+	}
+}
+
+func TestDiscoverCIM(t *testing.T) {
+	r := Discover(context.Background(), capturedCIM(t, "win32_serialport.json", "win32_pnpentity.json"))
+	if len(r.Errors) > 0 {
+		t.Fatal(r.Errors)
+	}
+
+	var included, excluded []string
+	for _, c := range r.Candidates {
+		id := c.DeviceID
+		if id == "" {
+			id = c.Name
+		}
+		if c.Included {
+			included = append(included, id)
+		} else {
+			excluded = append(excluded, id)
+		}
+	}
+	if got, want := strings.Join(included, ","), "COM12,COM10,COM3,COM5,COM1,COM7"; got != want {
+		t.Errorf("included %s, want %s", got, want)
+	}
+	if got, want := strings.Join(excluded, ","), "USB Printing Support,ECP Printer Port (LPT1),COM11"; got != want {
+		t.Errorf("excluded %s, want %s", got, want)
+	}
+
+	best := r.Candidates[0]
+	if best.Fingerprint != "bt:DC:0D:30:A1:B2:C3" || best.Score != 70 || best.Source != "cim" {
+		t.Errorf("unexpected best candidate %+v", best)
+	}
+	if printers := r.Printers(); len(printers) != 6 || printers[0].DeviceID != "COM12" {
+		t.Errorf("Printers() = %+v", printers)
+	}
+
+	var report strings.Builder
+	r.WriteTo(&report)
+	for _, want := range []string{
+		"+  70 COM12         RPP02N (COM12) [cim]: Bluetooth serial port, name looks like a printer",
+		"-  90 (no port)     USB Printing Support [cim]: USB printer class, receipt printer vendor Winbond, USB printer class device without a COM port",
+		"-   0 COM11         Standard Serial over Bluetooth link (COM11) [cim]: incoming Bluetooth port, printers use the outgoing one",
+		"+   0 COM1          Communications Port (COM1) [cim]: built-in serial port",
+	} {
+		if !strings.Contains(report.String(), want) {
+			t.Errorf("report misses %q:\n%s", want, report.String())
+		}
+	}
+}
+
+func TestDiscoverCIMQueryFails(t *testing.T) {
+	r := Discover(context.Background(), capturedCIM(t, "", "win32_pnpentity.json"))
+	if len(r.Errors) != 1 || !strings.Contains(r.Errors[0].Error(), "cim: Get-CimInstance : Access denied") {
+		t.Errorf("Errors = %v", r.Errors)
+	}
+	if printers := r.Printers(); len(printers) != 4 || printers[0].DeviceID != "COM12" {
+		t.Errorf("Printers() = %+v", printers)
+	}
+}
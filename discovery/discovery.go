@@ -0,0 +1,180 @@
+// Package discovery finds the printers attached to this computer, so the
+// agent can list them for the user to choose from or pick one on its own
+// when no device is configured.
+package discovery
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"strings"
+
+	"cleanlink/printer/transport"
+)
+
+// PrinterInfo holds printer information. Name, DeviceID and PNPDeviceID
+// match the Win32_SerialPort properties PowerShell prints as JSON.
+type PrinterInfo struct {
+	Name        string
+	DeviceID    string // e.g. COM10, /dev/rfcomm0 or /dev/usb/lp0
+	PNPDeviceID string // Windows device instance path, e.g. USB\VID_0416&PID_5011\...
+
+	Transport   string // Transport kind to print with: com, tty or file
+	VendorID    string // USB vendor ID, e.g. 0416
+	ProductID   string // USB product ID, e.g. 5011
+	Serial      string // USB serial number
+	MAC         string // Bluetooth address, e.g. 00:11:22:AA:BB:CC
+	Fingerprint string // Stable identity, see Identify
+}
+
+// Identify fills in what p's PNPDeviceID and DeviceID tell about the
+// printer and sets its fingerprint. The fingerprint survives the port
+// being renumbered: it is the Bluetooth address, else the USB vendor and
+// product ID with the serial number if the device has one, and only for
+// anything else the port itself. Two identical USB printers without serial
+// numbers share a fingerprint.
+func Identify(p PrinterInfo) PrinterInfo {
+	if p.PNPDeviceID != "" {
+		vid, pid, serial, mac := parsePNP(p.PNPDeviceID)
+		p.VendorID = cmp.Or(p.VendorID, vid)
+		p.ProductID = cmp.Or(p.ProductID, pid)
+		p.Serial = cmp.Or(p.Serial, serial)
+		p.MAC = cmp.Or(p.MAC, mac)
+	}
+	if p.Transport == "" && strings.HasPrefix(strings.ToUpper(p.DeviceID), "COM") {
+		p.Transport = transport.KindCOM
+	} else if p.Transport == "" {
+		p.Transport = Configure(transport.Config{}, p.DeviceID).Type
+	}
+
+	switch {
+	case p.MAC != "":
+		p.Fingerprint = "bt:" + p.MAC
+	case p.VendorID != "" && p.Serial != "":
+		p.Fingerprint = "usb:" + p.VendorID + ":" + p.ProductID + ":" + p.Serial
+	case p.VendorID != "":
+		p.Fingerprint = "usb:" + p.VendorID + ":" + p.ProductID
+	default:
+		p.Fingerprint = "port:" + p.DeviceID
+	}
+	return p
+}
+
+// parsePNP decodes the USB IDs and serial number or the Bluetooth address
+// from a Windows device instance path such as
+//
+//	USB\VID_067B&PID_2303\A1B2C3
+//	FTDIBUS\VID_0403+PID_6001+A50285BIA\0000
+//	BTHENUM\{00001101-...}_LOCALMFG&0002\7&2C4C5AE5&0&001122AABBCC_C00000000
+func parsePNP(pnp string) (vid, pid, serial, mac string) {
+	parts := strings.Split(pnp, `\`)
+	if len(parts) < 3 {
+		return "", "", "", ""
+	}
+	bus, ids, instance := strings.ToUpper(parts[0]), strings.ToUpper(parts[1]), parts[2]
+
+	if bus == "BTHENUM" {
+		// Outgoing serial ports end in the remote address, incoming ones
+		// in zeros
+		last := instance[strings.LastIndex(instance, "&")+1:]
+		addr, _, _ := strings.Cut(last, "_")
+		if len(addr) == 12 && addr != "000000000000" {
+			mac = formatMAC(addr)
+		}
+		return "", "", "", mac
+	}
+
+	for _, f := range strings.FieldsFunc(ids, func(r rune) bool { return r == '&' || r == '+' }) {
+		switch {
+		case strings.HasPrefix(f, "VID_"):
+			vid = strings.ToLower(strings.TrimPrefix(f, "VID_"))
+		case strings.HasPrefix(f, "PID_"):
+			pid = strings.ToLower(strings.TrimPrefix(f, "PID_"))
+		case bus == "FTDIBUS":
+			// FTDI puts the serial number after the IDs
+			serial = f
+		}
+	}
+	if vid == "" {
+		return "", "", "", ""
+	}
+	// Windows makes up an instance ID containing '&' for devices without
+	// a serial number
+	if serial == "" && bus == "USB" && !strings.Contains(instance, "&") {
+		serial = instance
+	}
+	return vid, pid, serial, ""
+}
+
+// formatMAC writes a 12 digit hex address as 00:11:22:AA:BB:CC
+func formatMAC(addr string) string {
+	addr = strings.ToUpper(strings.ReplaceAll(addr, ":", ""))
+	if len(addr) != 12 {
+		return addr
+	}
+	var b strings.Builder
+	for i := 0; i < 12; i += 2 {
+		if i > 0 {
+			b.WriteByte(':')
+		}
+		b.WriteString(addr[i : i+2])
+	}
+	return b.String()
+}
+
+// Pick returns the device of the first printer whose name or device
+// contains match, ignoring case, or without a match the first printer.
+// List returns the likeliest printer first.
+func Pick(printers []PrinterInfo, match string) (string, error) {
+	if match != "" {
+		want := strings.ToLower(match)
+		for _, p := range printers {
+			if strings.Contains(strings.ToLower(p.Name), want) || strings.Contains(strings.ToLower(p.DeviceID), want) {
+				return p.DeviceID, nil
+			}
+		}
+		return "", fmt.Errorf("no printer matching %q found. Please check if printer is connected", match)
+	}
+
+	if len(printers) == 0 {
+		return "", errors.New("no printer found. Please check if printer is connected")
+	}
+	return printers[0].DeviceID, nil
+}
+
+// Resolve finds the printer with pc's fingerprint among printers and
+// returns pc pointed at its current port. ok is false if the printer is
+// not attached; pc is then returned unchanged.
+func Resolve(printers []PrinterInfo, pc transport.Config) (transport.Config, bool) {
+	if pc.Fingerprint == "" {
+		return pc, false
+	}
+	for _, p := range printers {
+		if p.Fingerprint == pc.Fingerprint {
+			return Configure(pc, p.DeviceID), true
+		}
+	}
+	return pc, false
+}
+
+// Select returns pc pointed at printer p, remembering p's fingerprint so
+// the selection can be resolved again after the port changes
+func Select(pc transport.Config, p PrinterInfo) transport.Config {
+	pc = Configure(pc, p.DeviceID)
+	pc.Fingerprint = p.Fingerprint
+	return pc
+}
+
+// Configure returns pc pointed at a discovered device. USB printer class
+// nodes such as /dev/usb/lp0 take raw writes; any other Linux device is a
+// serial port.
+func Configure(pc transport.Config, device string) transport.Config {
+	pc.Device = device
+	switch {
+	case strings.HasPrefix(device, "/dev/usb/lp"):
+		pc.Type = transport.KindFile
+	case strings.HasPrefix(device, "/dev/"):
+		pc.Type = transport.KindTTY
+	}
+	return pc
+}
@@ -0,0 +1,104 @@
+package discovery
+
+import (
+	"testing"
+
+	"cleanlink/printer/transport"
+)
+
+func TestPick(t *testing.T) {
+	printers := []PrinterInfo{
+		{Name: "USB Serial Device (USB 0483:5740)", DeviceID: "/dev/ttyACM0"},
+		{Name: "Bluetooth Serial Port 00:11:22:AA:BB:CC (channel 1)", DeviceID: "/dev/rfcomm0"},
+		{Name: "Winbond POS-58 (USB 0416:5011)", DeviceID: "/dev/usb/lp0"},
+	}
+
+	tests := []struct {
+		match, want string
+		wantErr     bool
+	}{
+		{"", "/dev/ttyACM0", false},
+		{"pos-58", "/dev/usb/lp0", false},
+		{"ttyACM", "/dev/ttyACM0", false},
+		{"TM-T20", "", true},
+	}
+	for _, tt := range tests {
+		got, err := Pick(printers, tt.match)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("Pick(%q) = %q, %v; want %q", tt.match, got, err, tt.want)
+		}
+	}
+
+	if _, err := Pick(nil, ""); err == nil {
+		t.Error("Pick without printers succeeded")
+	}
+}
+
+func TestConfigure(t *testing.T) {
+	pc := transport.Config{Type: transport.KindCOM}
+	for device, kind := range map[string]string{
+		"COM10":        transport.KindCOM,
+		"/dev/rfcomm0": transport.KindTTY,
+		"/dev/usb/lp0": transport.KindFile,
+	} {
+		if got := Configure(pc, device); got.Type != kind || got.Device != device {
+			t.Errorf("Configure(%q) = %+v, want %s", device, got, kind)
+		}
+	}
+}
+
+func TestIdentify(t *testing.T) {
+	tests := []struct {
+		in   PrinterInfo
+		want PrinterInfo
+	}{{
+		PrinterInfo{DeviceID: "COM10", PNPDeviceID: `BTHENUM\{00001101-0000-1000-8000-00805F9B34FB}_LOCALMFG&0002\7&2C4C5AE5&0&001122AABBCC_C00000000`},
+		PrinterInfo{Transport: "com", MAC: "00:11:22:AA:BB:CC", Fingerprint: "bt:00:11:22:AA:BB:CC"},
+	}, {
+		// Incoming Bluetooth port, no remote address
+		PrinterInfo{DeviceID: "COM11", PNPDeviceID: `BTHENUM\{00001101-0000-1000-8000-00805F9B34FB}_LOCALMFG&0000\7&2C4C5AE5&0&000000000000_00000000`},
+		PrinterInfo{Transport: "com", Fingerprint: "port:COM11"},
+	}, {
+		PrinterInfo{DeviceID: "COM3", PNPDeviceID: `USB\VID_067B&PID_2303\A1B2C3`},
+		PrinterInfo{Transport: "com", VendorID: "067b", ProductID: "2303", Serial: "A1B2C3", Fingerprint: "usb:067b:2303:A1B2C3"},
+	}, {
+		// No serial number, Windows made up the instance ID
+		PrinterInfo{DeviceID: "COM4", PNPDeviceID: `USB\VID_0416&PID_5011&MI_00\6&1A2B3C4D&0&0000`},
+		PrinterInfo{Transport: "com", VendorID: "0416", ProductID: "5011", Fingerprint: "usb:0416:5011"},
+	}, {
+		PrinterInfo{DeviceID: "COM5", PNPDeviceID: `FTDIBUS\VID_0403+PID_6001+A50285BIA\0000`},
+		PrinterInfo{Transport: "com", VendorID: "0403", ProductID: "6001", Serial: "A50285BIA", Fingerprint: "usb:0403:6001:A50285BIA"},
+	}, {
+		PrinterInfo{DeviceID: "COM1", PNPDeviceID: `ACPI\PNP0501\0`},
+		PrinterInfo{Transport: "com", Fingerprint: "port:COM1"},
+	}}
+	for _, tt := range tests {
+		got := Identify(tt.in)
+		tt.want.DeviceID, tt.want.PNPDeviceID = tt.in.DeviceID, tt.in.PNPDeviceID
+		if got != tt.want {
+			t.Errorf("Identify(%s) =\n%+v\nwant\n%+v", tt.in.PNPDeviceID, got, tt.want)
+		}
+	}
+}
+
+func TestResolve(t *testing.T) {
+	printers := []PrinterInfo{
+		Identify(PrinterInfo{DeviceID: "COM3", PNPDeviceID: `USB\VID_067B&PID_2303\A1B2C3`}),
+		Identify(PrinterInfo{DeviceID: "COM12", PNPDeviceID: `BTHENUM\{00001101-0000-1000-8000-00805F9B34FB}_LOCALMFG&0002\7&2C4C5AE5&0&001122AABBCC_C00000000`}),
+	}
+
+	// Re-paired printer moved from COM10 to COM12
+	saved := transport.Config{Type: transport.KindCOM, Device: "COM10", Fingerprint: "bt:00:11:22:AA:BB:CC"}
+	if pc, ok := Resolve(printers, saved); !ok || pc.Device != "COM12" || pc.Fingerprint != saved.Fingerprint {
+		t.Errorf("Resolve() = %+v, %v; want COM12", pc, ok)
+	}
+
+	gone := transport.Config{Type: transport.KindCOM, Device: "COM7", Fingerprint: "usb:0416:5011"}
+	if pc, ok := Resolve(printers, gone); ok || pc != gone {
+		t.Errorf("Resolve() of a missing printer = %+v, %v", pc, ok)
+	}
+
+	if pc := Select(transport.Config{Type: transport.KindCOM}, printers[0]); pc.Device != "COM3" || pc.Fingerprint != "usb:067b:2303:A1B2C3" {
+		t.Errorf("Select() = %+v", pc)
+	}
+}
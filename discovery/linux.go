@@ -0,0 +1,140 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// ttyPrefixes are the tty devices a receipt printer shows up as: USB serial
+// adapters, USB CDC ACM printers and Bluetooth serial links
+var ttyPrefixes = []string{"ttyUSB", "ttyACM", "rfcomm"}
+
+// Linux lists the serial and USB printers of a Linux system whose file
+// system is mounted at root, normally "/". Tests pass a fake sysfs tree.
+func Linux(root string) ([]PrinterInfo, error) {
+	var printers []PrinterInfo
+
+	ttys, err := os.ReadDir(filepath.Join(root, "sys/class/tty"))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	for _, e := range ttys {
+		name := e.Name()
+		if !slices.ContainsFunc(ttyPrefixes, func(p string) bool { return strings.HasPrefix(name, p) }) {
+			continue
+		}
+		p := ttyInfo(root, filepath.Join(root, "sys/class/tty", name), name)
+		p.DeviceID = "/dev/" + name
+		printers = append(printers, Identify(p))
+	}
+
+	lps, err := filepath.Glob(filepath.Join(root, "dev/usb/lp*"))
+	if err != nil {
+		return nil, err
+	}
+	for _, lp := range lps {
+		name := filepath.Base(lp)
+		p := usbInfo(root, filepath.Join(root, "sys/class/usbmisc", name), "USB Printer")
+		p.DeviceID = "/dev/usb/" + name
+		printers = append(printers, Identify(p))
+	}
+
+	slices.SortFunc(printers, func(a, b PrinterInfo) int { return strings.Compare(a.DeviceID, b.DeviceID) })
+	return printers, nil
+}
+
+// Sysfs finds Linux printers in sysfs below Root, normally "/"
+type Sysfs struct {
+	Root string
+}
+
+func (Sysfs) Name() string { return "sysfs" }
+
+func (p Sysfs) Candidates(context.Context) ([]Candidate, error) {
+	printers, err := Linux(p.Root)
+	candidates := make([]Candidate, len(printers))
+	for i, printer := range printers {
+		candidates[i] = Candidate{PrinterInfo: printer}
+	}
+	return candidates, err
+}
+
+// ttyInfo describes the tty whose sysfs directory is dir
+func ttyInfo(root, dir, name string) PrinterInfo {
+	if strings.HasPrefix(name, "rfcomm") {
+		p := PrinterInfo{Name: "Bluetooth Serial Port"}
+		if addr := attr(dir, "address"); addr != "" && addr != "00:00:00:00:00:00" {
+			p.MAC = formatMAC(addr)
+			p.Name += " " + p.MAC
+		}
+		if ch := attr(dir, "channel"); ch != "" {
+			p.Name += " (channel " + ch + ")"
+		}
+		return p
+	}
+	return usbInfo(root, dir, "USB Serial Device")
+}
+
+// usbInfo describes the device behind dir by the USB device it belongs to,
+// named after its manufacturer and product strings with its vendor and
+// product ID
+func usbInfo(root, dir, fallback string) PrinterInfo {
+	usb := usbDevice(root, filepath.Join(dir, "device"))
+	if usb == "" {
+		return PrinterInfo{Name: fallback}
+	}
+
+	p := PrinterInfo{
+		VendorID:  strings.ToLower(attr(usb, "idVendor")),
+		ProductID: strings.ToLower(attr(usb, "idProduct")),
+		Serial:    attr(usb, "serial"),
+	}
+	var parts []string
+	if s := attr(usb, "manufacturer"); s != "" {
+		parts = append(parts, s)
+	}
+	if s := attr(usb, "product"); s != "" {
+		parts = append(parts, s)
+	}
+	if len(parts) == 0 {
+		parts = append(parts, fallback)
+	}
+	p.Name = fmt.Sprintf("%s (USB %s:%s)", strings.Join(parts, " "), p.VendorID, p.ProductID)
+	return p
+}
+
+// usbDevice returns the sysfs directory of the USB device that link leads
+// into: the first directory at or above its target with an idVendor file.
+// It returns "" for devices not on USB.
+func usbDevice(root, link string) string {
+	dir, err := filepath.EvalSymlinks(link)
+	if err != nil {
+		return ""
+	}
+	stop := filepath.Join(root, "sys")
+	if resolved, err := filepath.EvalSymlinks(stop); err == nil {
+		stop = resolved
+	}
+	for strings.HasPrefix(dir, stop+string(filepath.Separator)) {
+		if attr(dir, "idVendor") != "" {
+			return dir
+		}
+		dir = filepath.Dir(dir)
+	}
+	return ""
+}
+
+// attr reads a sysfs attribute, or returns "" if it does not exist
+func attr(dir, name string) string {
+	data, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
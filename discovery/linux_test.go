@@ -0,0 +1,143 @@
+package discovery
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// fakeSysfs builds a system with a USB serial adapter, a CDC ACM printer,
+// a USB printer class device, a Bluetooth link and a built-in serial port
+func fakeSysfs(t *testing.T) string {
+	t.Helper()
+	root := t.TempDir()
+
+	write := func(path, content string) {
+		t.Helper()
+		path = filepath.Join(root, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content+"\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	link := func(path, target string) {
+		t.Helper()
+		path = filepath.Join(root, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(target, path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	usb := func(dir, vid, pid, manufacturer, product string) {
+		write(dir+"/idVendor", vid)
+		write(dir+"/idProduct", pid)
+		if manufacturer != "" {
+			write(dir+"/manufacturer", manufacturer)
+		}
+		if product != "" {
+			write(dir+"/product", product)
+		}
+	}
+
+	// USB serial adapter: tty -> usb-serial port -> interface -> device
+	usb("sys/devices/pci0000:00/usb1/1-1", "067b", "2303", "Prolific Technology Inc.", "USB-Serial Controller")
+	write("sys/devices/pci0000:00/usb1/1-1/serial", "A1B2C3")
+	write("sys/devices/pci0000:00/usb1/1-1/1-1:1.0/ttyUSB0/tty/ttyUSB0/dev", "188:0")
+	link("sys/devices/pci0000:00/usb1/1-1/1-1:1.0/ttyUSB0/tty/ttyUSB0/device", "../../../ttyUSB0")
+	link("sys/class/tty/ttyUSB0", "../../devices/pci0000:00/usb1/1-1/1-1:1.0/ttyUSB0/tty/ttyUSB0")
+
+	// CDC ACM printer without manufacturer strings
+	usb("sys/devices/pci0000:00/usb1/1-2", "0483", "5740", "", "")
+	write("sys/devices/pci0000:00/usb1/1-2/1-2:1.0/tty/ttyACM0/dev", "166:0")
+	link("sys/devices/pci0000:00/usb1/1-2/1-2:1.0/tty/ttyACM0/device", "../../../1-2:1.0")
+	link("sys/class/tty/ttyACM0", "../../devices/pci0000:00/usb1/1-2/1-2:1.0/tty/ttyACM0")
+
+	// USB printer class device
+	usb("sys/devices/pci0000:00/usb1/1-3", "0416", "5011", "Winbond", "POS-58")
+	write("sys/devices/pci0000:00/usb1/1-3/1-3:1.0/usbmisc/lp0/dev", "180:0")
+	link("sys/devices/pci0000:00/usb1/1-3/1-3:1.0/usbmisc/lp0/device", "../../../1-3:1.0")
+	link("sys/class/usbmisc/lp0", "../../devices/pci0000:00/usb1/1-3/1-3:1.0/usbmisc/lp0")
+	write("dev/usb/lp0", "")
+
+	// Bluetooth serial link
+	write("sys/devices/virtual/tty/rfcomm0/address", "00:11:22:aa:bb:cc")
+	write("sys/devices/virtual/tty/rfcomm0/channel", "1")
+	link("sys/class/tty/rfcomm0", "../../devices/virtual/tty/rfcomm0")
+
+	// Built-in serial port and console, never a printer
+	write("sys/devices/platform/serial8250/tty/ttyS0/dev", "4:64")
+	link("sys/class/tty/ttyS0", "../../devices/platform/serial8250/tty/ttyS0")
+	write("sys/devices/virtual/tty/tty0/dev", "4:0")
+	link("sys/class/tty/tty0", "../../devices/virtual/tty/tty0")
+
+	return root
+}
+
+func TestLinux(t *testing.T) {
+	got, err := Linux(fakeSysfs(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []PrinterInfo{{
+		Name:        "Bluetooth Serial Port 00:11:22:AA:BB:CC (channel 1)",
+		DeviceID:    "/dev/rfcomm0",
+		Transport:   "tty",
+		MAC:         "00:11:22:AA:BB:CC",
+		Fingerprint: "bt:00:11:22:AA:BB:CC",
+	}, {
+		Name:        "USB Serial Device (USB 0483:5740)",
+		DeviceID:    "/dev/ttyACM0",
+		Transport:   "tty",
+		VendorID:    "0483",
+		ProductID:   "5740",
+		Fingerprint: "usb:0483:5740",
+	}, {
+		Name:        "Prolific Technology Inc. USB-Serial Controller (USB 067b:2303)",
+		DeviceID:    "/dev/ttyUSB0",
+		Transport:   "tty",
+		VendorID:    "067b",
+		ProductID:   "2303",
+		Serial:      "A1B2C3",
+		Fingerprint: "usb:067b:2303:A1B2C3",
+	}, {
+		Name:        "Winbond POS-58 (USB 0416:5011)",
+		DeviceID:    "/dev/usb/lp0",
+		Transport:   "file",
+		VendorID:    "0416",
+		ProductID:   "5011",
+		Fingerprint: "usb:0416:5011",
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Linux() =\n%v\nwant\n%v", got, want)
+	}
+}
+
+func TestLinuxEmpty(t *testing.T) {
+	got, err := Linux(t.TempDir())
+	if err != nil || len(got) != 0 {
+		t.Errorf("Linux() = %v, %v; want no printers", got, err)
+	}
+}
+
+func TestDiscoverSysfs(t *testing.T) {
+	r := Discover(context.Background(), Sysfs{Root: fakeSysfs(t)})
+
+	var got []string
+	for _, p := range r.Printers() {
+		got = append(got, p.DeviceID)
+	}
+	if want := "/dev/usb/lp0,/dev/rfcomm0,/dev/ttyUSB0,/dev/ttyACM0"; strings.Join(got, ",") != want {
+		t.Errorf("Printers() = %v, want %s", got, want)
+	}
+	if c := r.Candidates[0]; c.Score != 120 || c.Source != "sysfs" {
+		t.Errorf("unexpected best candidate %+v", c)
+	}
+}
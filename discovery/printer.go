@@ -0,0 +1,93 @@
+package discovery
+
+import (
+	"fmt"
+	"sync"
+
+	"cleanlink/printer/transport"
+)
+
+// list finds the attached printers; tests replace it
+var list = List
+
+// Printer returns the function that gives the server the printer for each
+// job. A configured device is used as is. Without one the printer whose
+// name or device contains match, or else the likeliest printer, is looked
+// up, and a printer saved by fingerprint is looked up on its current port.
+// The printer found is kept and only looked up again after opening or
+// writing to it failed, so it keeps working after it is re-paired on
+// another port without listing the printers for every job.
+func Printer(pc transport.Config, match string) (func() (transport.Transport, error), error) {
+	serial := pc.Type == transport.KindCOM || pc.Type == transport.KindTTY
+	if !serial || pc.Device != "" {
+		printer, err := transport.New(pc)
+		if err != nil {
+			return nil, err
+		}
+		if pc.Fingerprint == "" {
+			return func() (transport.Transport, error) { return printer, nil }, nil
+		}
+	}
+
+	r := &resolver{pc: pc, match: match}
+	// A printer that is not attached yet is looked up again on the first job
+	r.resolve()
+	return r.get, nil
+}
+
+// resolver keeps the printer discovery found until it fails
+type resolver struct {
+	pc    transport.Config
+	match string
+
+	mu      sync.Mutex
+	printer transport.Transport
+}
+
+// get returns the kept printer, looking it up again if the last attempt to
+// use it failed
+func (r *resolver) get() (transport.Transport, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.printer != nil && r.printer.Status().LastError == "" {
+		return r.printer, nil
+	}
+	return r.resolve()
+}
+
+// resolve looks the printer up and keeps it. The kept printer is reused if
+// it is still on the same port, so the status monitor and the jobs share
+// one connection.
+func (r *resolver) resolve() (transport.Transport, error) {
+	printers, err := list()
+	pc, _ := Resolve(printers, r.pc)
+	if pc.Device == "" {
+		if err != nil {
+			return nil, err
+		}
+		device, err := Pick(printers, r.match)
+		if err != nil {
+			return nil, err
+		}
+		pc = Configure(pc, device)
+	}
+
+	from := r.pc.Device
+	if r.printer != nil {
+		s := r.printer.Status()
+		if s.Kind == pc.Type && s.Address == pc.Device {
+			return r.printer, nil
+		}
+		from = s.Address
+	}
+	printer, err := transport.New(pc)
+	if err != nil {
+		return nil, err
+	}
+	if pc.Fingerprint != "" && from != "" && from != pc.Device {
+		fmt.Printf("[CONFIG] Printer %s moved from %s to %s\n", pc.Fingerprint, from, pc.Device)
+	}
+	r.printer = printer
+	return printer, nil
+}
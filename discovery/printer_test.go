@@ -0,0 +1,94 @@
+package discovery
+
+import (
+	"path/filepath"
+	"testing"
+
+	"cleanlink/printer/transport"
+)
+
+// fakeList replaces list with one returning *printers, and counts the calls
+func fakeList(t *testing.T, printers *[]PrinterInfo) *int {
+	t.Helper()
+
+	calls := 0
+	old := list
+	list = func() ([]PrinterInfo, error) {
+		calls++
+		return *printers, nil
+	}
+	t.Cleanup(func() { list = old })
+	return &calls
+}
+
+func TestPrinterKeepsResolvedPrinter(t *testing.T) {
+	dir := t.TempDir()
+	first, second := filepath.Join(dir, "rfcomm0"), filepath.Join(dir, "rfcomm1")
+	printers := []PrinterInfo{{DeviceID: first, Fingerprint: "bt:00:11:22:AA:BB:CC"}}
+	calls := fakeList(t, &printers)
+
+	printer, err := Printer(transport.Config{Type: transport.KindTTY, Device: first, Fingerprint: "bt:00:11:22:AA:BB:CC"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 3 {
+		if p, err := printer(); err != nil || p.Status().Address != first {
+			t.Fatalf("printer() = %v, %v; want %s", p, err, first)
+		}
+	}
+	if *calls != 1 {
+		t.Errorf("printers listed %d times, want once at startup", *calls)
+	}
+
+	// The printer is re-paired on another port: opening the old one fails
+	// and the next job finds it on the new one
+	printers = []PrinterInfo{{DeviceID: second, Fingerprint: "bt:00:11:22:AA:BB:CC"}}
+	p, _ := printer()
+	if err := p.Open(); err == nil {
+		t.Fatal("opened a missing device")
+	}
+	if p, err := printer(); err != nil || p.Status().Address != second {
+		t.Errorf("printer() after a failed open = %v, %v; want %s", p, err, second)
+	}
+	if *calls != 2 {
+		t.Errorf("printers listed %d times, want 2", *calls)
+	}
+}
+
+func TestPrinterAutoDetect(t *testing.T) {
+	var printers []PrinterInfo
+	calls := fakeList(t, &printers)
+
+	printer, err := Printer(transport.Config{Type: transport.KindTTY}, "pos-58")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing attached at startup: every job looks again until one is found
+	if _, err := printer(); err == nil {
+		t.Error("printer() without printers succeeded")
+	}
+	printers = []PrinterInfo{{Name: "Winbond POS-58", DeviceID: "/dev/usb/lp0"}}
+	if p, err := printer(); err != nil || p.Status().Kind != transport.KindFile || p.Status().Address != "/dev/usb/lp0" {
+		t.Errorf("printer() = %v, %v; want /dev/usb/lp0", p, err)
+	}
+	if _, err := printer(); err != nil || *calls != 3 {
+		t.Errorf("printers listed %d times, want 3", *calls)
+	}
+}
+
+func TestPrinterConfiguredDevice(t *testing.T) {
+	calls := fakeList(t, new([]PrinterInfo))
+
+	printer, err := Printer(transport.Config{Type: transport.KindTTY, Device: "/dev/rfcomm0"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p, err := printer(); err != nil || p.Status().Address != "/dev/rfcomm0" || *calls != 0 {
+		t.Errorf("printer() = %v, %v after %d lookups", p, err, *calls)
+	}
+
+	if _, err := Printer(transport.Config{Type: transport.KindTTY, Device: "/dev/rfcomm0", SerialOptions: transport.SerialOptions{Parity: "bogus"}}, ""); err == nil {
+		t.Error("invalid serial options accepted")
+	}
+}
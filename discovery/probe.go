@@ -0,0 +1,41 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"runtime"
+)
+
+// Probe finds Windows COM ports the CIM classes miss by opening COM1 up
+// to COM<Ports>
+type Probe struct {
+	Ports int // Default 16
+}
+
+func (Probe) Name() string { return "probe" }
+
+func (p Probe) Candidates(ctx context.Context) ([]Candidate, error) {
+	if runtime.GOOS != "windows" {
+		return nil, nil
+	}
+
+	n := p.Ports
+	if n == 0 {
+		n = 16
+	}
+	var candidates []Candidate
+	for i := 1; i <= n && ctx.Err() == nil; i++ {
+		port := fmt.Sprintf("COM%d", i)
+		f, err := os.OpenFile(`\\.\`+port, os.O_WRONLY, 0)
+		if err != nil {
+			continue
+		}
+		f.Close()
+		candidates = append(candidates, Candidate{PrinterInfo: PrinterInfo{
+			Name:     fmt.Sprintf("Serial/USB Printer on %s", port),
+			DeviceID: port,
+		}})
+	}
+	return candidates, nil
+}
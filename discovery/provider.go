@@ -0,0 +1,138 @@
+package discovery
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+	"slices"
+	"strings"
+)
+
+// Provider finds printer candidates in one place, e.g. sysfs on Linux or
+// the CIM (WMI) classes on Windows
+type Provider interface {
+	// Name identifies the provider in reports, e.g. "cim"
+	Name() string
+	// Candidates lists the devices that might be printers
+	Candidates(ctx context.Context) ([]Candidate, error)
+}
+
+// Candidate is a device a provider found and what Discover made of it
+type Candidate struct {
+	PrinterInfo
+	Source  string // Provider that found it
+	Service string // Windows driver service, e.g. usbser or usbprint
+
+	Score    int      // Higher is more likely an ESC/POS printer
+	Included bool     // Whether it is offered as a printer
+	Reasons  []string // Why it scored and was included or excluded
+}
+
+// Report is the outcome of Discover: every candidate, best first, and the
+// providers that failed
+type Report struct {
+	Candidates []Candidate
+	Errors     []error
+}
+
+// Discover asks every provider for candidates, scores them and ranks the
+// likely printers first. A device found by several providers is listed
+// once, as the first provider described it. A failing provider is
+// recorded in the report and the others still run.
+func Discover(ctx context.Context, providers ...Provider) *Report {
+	r := &Report{}
+	seen := map[string]bool{}
+	for _, p := range providers {
+		found, err := p.Candidates(ctx)
+		if err != nil {
+			r.Errors = append(r.Errors, fmt.Errorf("%s: %w", p.Name(), err))
+		}
+		for _, c := range found {
+			key := strings.ToUpper(cmp.Or(c.DeviceID, c.PNPDeviceID))
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+
+			c.Source = p.Name()
+			c.PrinterInfo = Identify(c.PrinterInfo)
+			score(&c)
+			r.Candidates = append(r.Candidates, c)
+		}
+	}
+
+	slices.SortStableFunc(r.Candidates, func(a, b Candidate) int {
+		if a.Included != b.Included {
+			if a.Included {
+				return -1
+			}
+			return 1
+		}
+		return b.Score - a.Score
+	})
+	return r
+}
+
+// Printers returns the included candidates, best first
+func (r *Report) Printers() []PrinterInfo {
+	var printers []PrinterInfo
+	for _, c := range r.Candidates {
+		if c.Included {
+			printers = append(printers, c.PrinterInfo)
+		}
+	}
+	return printers
+}
+
+// WriteTo writes one line per candidate: "+" if it is offered and "-" if
+// not, its score, port, name and provider, and the reasons for the verdict.
+// Failed providers follow, marked "!".
+func (r *Report) WriteTo(w io.Writer) (int64, error) {
+	var b strings.Builder
+	for _, c := range r.Candidates {
+		mark := "-"
+		if c.Included {
+			mark = "+"
+		}
+		fmt.Fprintf(&b, "%s %3d %-13s %s [%s]: %s\n", mark, c.Score, cmp.Or(c.DeviceID, "(no port)"), c.Name, c.Source, strings.Join(c.Reasons, ", "))
+	}
+	for _, err := range r.Errors {
+		fmt.Fprintf(&b, "! %v\n", err)
+	}
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+// Default returns the providers for this operating system. Probe is not
+// one of them: opening every COM port is slow, so List only probes when
+// nothing else was found.
+func Default() []Provider {
+	switch runtime.GOOS {
+	case "linux":
+		return []Provider{Sysfs{Root: "/"}}
+	case "windows":
+		return []Provider{CIM{}}
+	}
+	return nil
+}
+
+// List returns the likely printers attached to this computer, best first
+func List() ([]PrinterInfo, error) {
+	providers := Default()
+	if providers == nil {
+		return nil, fmt.Errorf("listing printers on %s: %w", runtime.GOOS, errors.ErrUnsupported)
+	}
+
+	r := Discover(context.Background(), providers...)
+	printers := r.Printers()
+	if len(printers) == 0 {
+		printers = Discover(context.Background(), Probe{}).Printers()
+	}
+	if len(printers) == 0 && len(r.Errors) > 0 {
+		return nil, errors.Join(r.Errors...)
+	}
+	return printers, nil
+}
@@ -0,0 +1,91 @@
+package discovery
+
+import (
+	"regexp"
+	"strings"
+
+	"cleanlink/printer/transport"
+)
+
+// printerVendors are USB vendors whose devices are receipt printers
+var printerVendors = map[string]string{
+	"0416": "Winbond", // POS-58 and most unbranded 58 mm printers
+	"04b8": "Epson",
+	"0519": "Star Micronics",
+	"0dd4": "Custom",
+	"0fe6": "ICS", // unbranded thermal printers
+	"1504": "Bixolon",
+	"154f": "SNBC",
+}
+
+// serialBridges are USB to serial chips printers are built around or
+// connected through
+var serialBridges = map[string]string{
+	"0403": "FTDI",
+	"067b": "Prolific",
+	"10c4": "Silicon Labs",
+	"1a86": "QinHeng",
+}
+
+// printerName matches names and model numbers of receipt printers
+var printerName = regexp.MustCompile(`(?i)\b(printer|pos|thermal|receipt)\b|\b(rpp|tm-|xp-|mpt-)`)
+
+// score rates how likely c is an ESC/POS printer the agent can print to
+// and decides whether it is offered, noting why
+func score(c *Candidate) {
+	reason := func(points int, why string) {
+		c.Score += points
+		c.Reasons = append(c.Reasons, why)
+	}
+	exclude := ""
+
+	switch c.Transport {
+	case transport.KindCOM, transport.KindTTY, transport.KindFile:
+	default:
+		if strings.EqualFold(c.Service, "usbprint") {
+			exclude = "USB printer class device without a COM port"
+		} else {
+			exclude = "no port the agent can print to"
+		}
+	}
+
+	bluetooth := strings.HasPrefix(strings.ToUpper(c.PNPDeviceID), `BTHENUM\`) || strings.HasPrefix(c.DeviceID, "/dev/rfcomm")
+	switch {
+	case c.MAC != "":
+		reason(40, "Bluetooth serial port")
+	case bluetooth && c.PNPDeviceID != "":
+		exclude = "incoming Bluetooth port, printers use the outgoing one"
+	case bluetooth:
+		reason(30, "Bluetooth serial port")
+	}
+
+	if c.Transport == transport.KindFile || strings.EqualFold(c.Service, "usbprint") {
+		reason(50, "USB printer class")
+	}
+	if vendor, ok := printerVendors[c.VendorID]; ok {
+		reason(40, "receipt printer vendor "+vendor)
+	} else if chip, ok := serialBridges[c.VendorID]; ok {
+		reason(20, "USB serial adapter "+chip)
+	}
+	if printerName.MatchString(c.Name) {
+		reason(30, "name looks like a printer")
+	}
+
+	switch {
+	case strings.Contains(strings.ToLower(c.Name), "modem"):
+		exclude = "modem"
+	case strings.HasPrefix(strings.ToUpper(c.PNPDeviceID), `ACPI\PNP0501`):
+		reason(0, "built-in serial port")
+	case c.Source == "probe":
+		reason(0, "port opens, nothing else is known")
+	}
+
+	if exclude != "" {
+		c.Reasons = append(c.Reasons, exclude)
+		return
+	}
+	c.Included = true
+	if len(c.Reasons) == 0 {
+		c.Reasons = append(c.Reasons, "serial port")
+	}
+}
@@ -0,0 +1,174 @@
+// Package escpos builds ESC/POS command streams for thermal receipt printers.
+//
+// Every agent (root, linux, windows-legacy and the app-windows builds) uses
+// this package instead of keeping its own copy of the esc* helpers, so a fix
+// to a printer command lands everywhere at once.
+package escpos
+
+// Control characters used by the ESC/POS command set
+const (
+	EOT = 0x04
+	DLE = 0x10
+	ESC = 0x1B
+	GS  = 0x1D
+)
+
+// ECLevel is the QR code error correction level
+type ECLevel byte
+
+const (
+	ECLow      ECLevel = 0x30 // L - recovers ~7% of the symbol
+	ECMedium   ECLevel = 0x31 // M - recovers ~15% of the symbol
+	ECQuartile ECLevel = 0x32 // Q - recovers ~25% of the symbol
+	ECHigh     ECLevel = 0x33 // H - recovers ~30% of the symbol
+)
+
+// QROptions controls how QR codes are printed
+type QROptions struct {
+	// Size is the module size in dots (1-16). For 57mm paper 6-8 is
+	// recommended; increase it if the code is too small to scan.
+	Size byte
+	// ErrorCorrection is the error correction level of the symbol
+	ErrorCorrection ECLevel
+}
+
+// DefaultQR is a good balance between size and scannability on 57mm paper
+var DefaultQR = QROptions{Size: 7, ErrorCorrection: ECMedium}
+
+// Builder accumulates ESC/POS commands and text into a single byte stream.
+// All methods return the builder so calls can be chained:
+//
+//	data := escpos.New().Init().AlignCenter().Bold(true).Line("Title").Bytes()
+type Builder struct {
+	buf []byte
+	qr  QROptions
+}
+
+// New returns an empty builder that prints QR codes with DefaultQR
+func New() *Builder {
+	return &Builder{qr: DefaultQR}
+}
+
+// WithQR sets the options used by subsequent QRCode calls
+func (b *Builder) WithQR(opts QROptions) *Builder {
+	b.qr = opts
+	return b
+}
+
+// Bytes returns the accumulated command stream
+func (b *Builder) Bytes() []byte {
+	return b.buf
+}
+
+// Len returns the number of bytes accumulated so far
+func (b *Builder) Len() int {
+	return len(b.buf)
+}
+
+// Raw appends bytes without interpretation
+func (b *Builder) Raw(p []byte) *Builder {
+	b.buf = append(b.buf, p...)
+	return b
+}
+
+// Text appends text as-is
+func (b *Builder) Text(s string) *Builder {
+	b.buf = append(b.buf, s...)
+	return b
+}
+
+// Line appends text followed by a line feed
+func (b *Builder) Line(s string) *Builder {
+	b.buf = append(b.buf, s...)
+	b.buf = append(b.buf, '\n')
+	return b
+}
+
+// Feed appends n line feeds
+func (b *Builder) Feed(n int) *Builder {
+	for i := 0; i < n; i++ {
+		b.buf = append(b.buf, '\n')
+	}
+	return b
+}
+
+// Init resets the printer to its power-on state (ESC @)
+func (b *Builder) Init() *Builder {
+	return b.Raw([]byte{ESC, 0x40})
+}
+
+// Bold turns emphasized mode on or off (ESC E n)
+func (b *Builder) Bold(on bool) *Builder {
+	return b.Raw([]byte{ESC, 0x45, flag(on)})
+}
+
+// Underline turns single underline on or off (ESC - n)
+func (b *Builder) Underline(on bool) *Builder {
+	return b.Raw([]byte{ESC, 0x2D, flag(on)})
+}
+
+// AlignLeft left-justifies the following lines (ESC a 0)
+func (b *Builder) AlignLeft() *Builder {
+	return b.Raw([]byte{ESC, 0x61, 0x00})
+}
+
+// AlignCenter centers the following lines (ESC a 1)
+func (b *Builder) AlignCenter() *Builder {
+	return b.Raw([]byte{ESC, 0x61, 0x01})
+}
+
+// AlignRight right-justifies the following lines (ESC a 2)
+func (b *Builder) AlignRight() *Builder {
+	return b.Raw([]byte{ESC, 0x61, 0x02})
+}
+
+// DoubleHeight selects double-height or normal characters (ESC ! n).
+// Print mode is a single register, so this also clears small font.
+func (b *Builder) DoubleHeight(on bool) *Builder {
+	if on {
+		return b.Raw([]byte{ESC, 0x21, 0x10})
+	}
+	return b.Raw([]byte{ESC, 0x21, 0x00})
+}
+
+// SmallFont selects font B or the normal font A (ESC ! n).
+// Print mode is a single register, so this also clears double height.
+func (b *Builder) SmallFont(on bool) *Builder {
+	if on {
+		return b.Raw([]byte{ESC, 0x21, 0x01})
+	}
+	return b.Raw([]byte{ESC, 0x21, 0x00})
+}
+
+// Cut performs a full paper cut (GS V 0)
+func (b *Builder) Cut() *Builder {
+	return b.Raw([]byte{GS, 0x56, 0x00})
+}
+
+// QRCode stores data in the printer's symbol buffer and prints it as a
+// model 2 QR code using the builder's QR options
+func (b *Builder) QRCode(data string) *Builder {
+	// Model 2 (recommended for most printers)
+	b.Raw([]byte{GS, 0x28, 0x6B, 0x04, 0x00, 0x31, 0x41, 0x32, 0x00})
+
+	// Module size
+	b.Raw([]byte{GS, 0x28, 0x6B, 0x03, 0x00, 0x31, 0x43, b.qr.Size})
+
+	// Error correction level
+	b.Raw([]byte{GS, 0x28, 0x6B, 0x03, 0x00, 0x31, 0x45, byte(b.qr.ErrorCorrection)})
+
+	// Store data; the length covers the three function bytes as well
+	n := len(data) + 3
+	b.Raw([]byte{GS, 0x28, 0x6B, byte(n % 256), byte(n / 256), 0x31, 0x50, 0x30})
+	b.Text(data)
+
+	// Print the stored symbol
+	return b.Raw([]byte{GS, 0x28, 0x6B, 0x03, 0x00, 0x31, 0x51, 0x30})
+}
+
+func flag(on bool) byte {
+	if on {
+		return 0x01
+	}
+	return 0x00
+}
@@ -0,0 +1,219 @@
+package escpos
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestCommands(t *testing.T) {
+	tests := []struct {
+		name string
+		b    *Builder
+		want []byte
+	}{
+		{"init", New().Init(), []byte{0x1B, 0x40}},
+		{"bold on", New().Bold(true), []byte{0x1B, 0x45, 0x01}},
+		{"bold off", New().Bold(false), []byte{0x1B, 0x45, 0x00}},
+		{"underline on", New().Underline(true), []byte{0x1B, 0x2D, 0x01}},
+		{"underline off", New().Underline(false), []byte{0x1B, 0x2D, 0x00}},
+		{"align left", New().AlignLeft(), []byte{0x1B, 0x61, 0x00}},
+		{"align center", New().AlignCenter(), []byte{0x1B, 0x61, 0x01}},
+		{"align right", New().AlignRight(), []byte{0x1B, 0x61, 0x02}},
+		{"double height on", New().DoubleHeight(true), []byte{0x1B, 0x21, 0x10}},
+		{"double height off", New().DoubleHeight(false), []byte{0x1B, 0x21, 0x00}},
+		{"small font on", New().SmallFont(true), []byte{0x1B, 0x21, 0x01}},
+		{"small font off", New().SmallFont(false), []byte{0x1B, 0x21, 0x00}},
+		{"cut", New().Cut(), []byte{0x1D, 0x56, 0x00}},
+		{"text", New().Text("abc"), []byte("abc")},
+		{"line", New().Line("abc"), []byte("abc\n")},
+		{"feed", New().Feed(3), []byte("\n\n\n")},
+		{"feed zero", New().Feed(0), nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.b.Bytes(); !bytes.Equal(got, tt.want) {
+				t.Errorf("got % X, want % X", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChaining(t *testing.T) {
+	got := New().Init().AlignCenter().Bold(true).Line("Hi").Bold(false).Cut().Bytes()
+	want := []byte{
+		0x1B, 0x40,
+		0x1B, 0x61, 0x01,
+		0x1B, 0x45, 0x01,
+		'H', 'i', '\n',
+		0x1B, 0x45, 0x00,
+		0x1D, 0x56, 0x00,
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("got % X, want % X", got, want)
+	}
+}
+
+func TestQRCode(t *testing.T) {
+	got := New().QRCode("ORD-1").Bytes()
+	want := []byte{
+		0x1D, 0x28, 0x6B, 0x04, 0x00, 0x31, 0x41, 0x32, 0x00, // model 2
+		0x1D, 0x28, 0x6B, 0x03, 0x00, 0x31, 0x43, 0x07, // size 7
+		0x1D, 0x28, 0x6B, 0x03, 0x00, 0x31, 0x45, 0x31, // EC level M
+		0x1D, 0x28, 0x6B, 0x08, 0x00, 0x31, 0x50, 0x30, // store 5+3 bytes
+		'O', 'R', 'D', '-', '1',
+		0x1D, 0x28, 0x6B, 0x03, 0x00, 0x31, 0x51, 0x30, // print
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("got % X, want % X", got, want)
+	}
+}
+
+func TestQRCodeOptions(t *testing.T) {
+	got := New().WithQR(QROptions{Size: 6, ErrorCorrection: ECHigh}).QRCode("x").Bytes()
+
+	if size := got[9+7]; size != 0x06 {
+		t.Errorf("size byte = %#x, want 0x06", size)
+	}
+	if ec := got[9+8+7]; ec != 0x33 {
+		t.Errorf("error correction byte = %#x, want 0x33", ec)
+	}
+}
+
+func TestQRCodeLongPayload(t *testing.T) {
+	data := strings.Repeat("a", 300)
+	got := New().QRCode(data).Bytes()
+
+	// Store command starts after the model, size and EC commands
+	store := got[9+8+8:]
+	// 300 + 3 = 303 = 0x012F
+	if store[3] != 0x2F || store[4] != 0x01 {
+		t.Errorf("pL pH = %#x %#x, want 0x2f 0x01", store[3], store[4])
+	}
+	if !bytes.Equal(store[8:8+300], []byte(data)) {
+		t.Error("payload not stored verbatim")
+	}
+}
+
+func TestQRCapacity(t *testing.T) {
+	tests := []struct {
+		version int
+		ec      ECLevel
+		want    int
+	}{
+		{1, ECLow, 17},
+		{9, ECMedium, 180},
+		{40, ECHigh, 1273},
+		{0, ECMedium, 0},
+		{41, ECMedium, 0},
+		{1, ECLevel(0), 0},
+	}
+	for _, tt := range tests {
+		if got := QRCapacity(tt.version, tt.ec); got != tt.want {
+			t.Errorf("QRCapacity(%d, %#x) = %d, want %d", tt.version, tt.ec, got, tt.want)
+		}
+	}
+}
+
+func TestQRMaxVersion(t *testing.T) {
+	// Size 7 on 57mm paper: 384/7 = 54 modules, version 9 is 53 wide
+	if got := DefaultQR.MaxVersion(32 * DotsPerColumn); got != 9 {
+		t.Errorf("MaxVersion(384) = %d, want 9", got)
+	}
+	if got := DefaultQR.MaxBytes(32 * DotsPerColumn); got != 180 {
+		t.Errorf("MaxBytes(384) = %d, want 180", got)
+	}
+	if got := (QROptions{Size: 16}).MaxVersion(300); got != 0 {
+		t.Errorf("MaxVersion too wide = %d, want 0", got)
+	}
+}
+
+func TestStatusRequest(t *testing.T) {
+	if got := StatusRequest(StatusPaper); !bytes.Equal(got, []byte{0x10, 0x04, 0x04}) {
+		t.Errorf("got % X", got)
+	}
+}
+
+func TestParseStatus(t *testing.T) {
+	tests := []struct {
+		name    string
+		replies [4]byte // Replies to DLE EOT 1-4
+		want    Status
+		str     string
+	}{
+		{"ready", [4]byte{0x16, 0x12, 0x12, 0x12}, Status{Online: true}, "ready"},
+		{"cover open", [4]byte{0x1E, 0x16, 0x12, 0x12}, Status{CoverOpen: true}, "offline, cover open"},
+		{"paper near end", [4]byte{0x16, 0x12, 0x12, 0x1E}, Status{Online: true, PaperNearEnd: true}, "paper near end"},
+		{"paper out", [4]byte{0x1E, 0x32, 0x12, 0x7E}, Status{PaperNearEnd: true, PaperOut: true}, "offline, paper out"},
+		{"cutter error", [4]byte{0x1E, 0x52, 0x1A, 0x12}, Status{CutterError: true}, "offline, cutter error"},
+		{"recoverable", [4]byte{0x1E, 0x52, 0x52, 0x12}, Status{Recoverable: true}, "offline, recoverable error"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got Status
+			for i, b := range tt.replies {
+				if err := got.Parse(byte(i+1), b); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+			if got.String() != tt.str {
+				t.Errorf("String() = %q, want %q", got.String(), tt.str)
+			}
+			if got.Ready() != (tt.str == "ready" || tt.str == "paper near end") {
+				t.Errorf("Ready() = %v", got.Ready())
+			}
+		})
+	}
+}
+
+func TestParseStatusInvalid(t *testing.T) {
+	var s Status
+	// 0x10 lacks the fixed bit 1 of a status reply, e.g. an ASB byte
+	if err := s.Parse(StatusPrinter, 0x10); err == nil {
+		t.Error("invalid reply accepted")
+	}
+	if err := s.Parse(5, 0x12); err == nil {
+		t.Error("unknown request accepted")
+	}
+}
+
+func TestParseASB(t *testing.T) {
+	tests := []struct {
+		name  string
+		block [4]byte
+		want  Status
+	}{
+		{"ready", [4]byte{0x10, 0x00, 0x00, 0x00}, Status{Online: true}},
+		{"cover open", [4]byte{0x38, 0x00, 0x00, 0x00}, Status{CoverOpen: true}},
+		{"paper near end", [4]byte{0x10, 0x00, 0x03, 0x00}, Status{Online: true, PaperNearEnd: true}},
+		{"paper out", [4]byte{0x18, 0x00, 0x0F, 0x00}, Status{PaperNearEnd: true, PaperOut: true}},
+		{"cutter error", [4]byte{0x18, 0x08, 0x00, 0x00}, Status{CutterError: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseASB(tt.block)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := ParseASB([4]byte{0x12, 0x00, 0x00, 0x00}); err == nil {
+		t.Error("DLE EOT reply accepted as a status block")
+	}
+}
+
+func TestEnableASB(t *testing.T) {
+	if got := EnableASB(ASBDefault); !bytes.Equal(got, []byte{0x1D, 0x61, 0x0E}) {
+		t.Errorf("got % X", got)
+	}
+}
@@ -0,0 +1,79 @@
+package escpos
+
+// DotsPerColumn is the width of one Font A character in printer dots
+const DotsPerColumn = 12
+
+// qrByteCapacity is the number of bytes a QR code of each version (1-40)
+// can hold in byte mode, per error correction level (ISO/IEC 18004)
+var qrByteCapacity = [40][4]int{
+	{17, 14, 11, 7},
+	{32, 26, 20, 14},
+	{53, 42, 32, 24},
+	{78, 62, 46, 34},
+	{106, 84, 60, 44},
+	{134, 106, 74, 58},
+	{154, 122, 86, 64},
+	{192, 152, 108, 84},
+	{230, 180, 130, 98},
+	{271, 213, 151, 119},
+	{321, 251, 177, 137},
+	{367, 287, 203, 155},
+	{425, 331, 241, 177},
+	{458, 362, 258, 194},
+	{520, 412, 292, 220},
+	{586, 450, 322, 250},
+	{644, 504, 364, 280},
+	{718, 560, 394, 310},
+	{792, 624, 442, 338},
+	{858, 666, 482, 382},
+	{929, 711, 509, 403},
+	{1003, 779, 565, 439},
+	{1091, 857, 611, 461},
+	{1171, 911, 661, 511},
+	{1273, 997, 715, 535},
+	{1367, 1059, 751, 593},
+	{1465, 1125, 805, 625},
+	{1528, 1190, 868, 658},
+	{1628, 1264, 908, 698},
+	{1732, 1370, 982, 742},
+	{1840, 1452, 1030, 790},
+	{1952, 1538, 1112, 842},
+	{2068, 1628, 1168, 898},
+	{2188, 1722, 1228, 958},
+	{2303, 1809, 1283, 983},
+	{2431, 1911, 1351, 1051},
+	{2563, 1989, 1423, 1093},
+	{2699, 2099, 1499, 1139},
+	{2809, 2213, 1579, 1219},
+	{2953, 2331, 1663, 1273},
+}
+
+// QRCapacity returns how many bytes a QR code of the given version and
+// error correction level can hold, or 0 for an invalid version or level
+func QRCapacity(version int, ec ECLevel) int {
+	level := int(ec) - int(ECLow)
+	if version < 1 || version > 40 || level < 0 || level > 3 {
+		return 0
+	}
+	return qrByteCapacity[version-1][level]
+}
+
+// MaxVersion returns the largest QR version that fits in dots printer dots
+// at the configured module size, or 0 if not even version 1 fits. A version
+// v symbol is 17+4v modules wide.
+func (o QROptions) MaxVersion(dots int) int {
+	if o.Size == 0 {
+		return 0
+	}
+	modules := dots / int(o.Size)
+	if modules < 21 {
+		return 0
+	}
+	return min((modules-17)/4, 40)
+}
+
+// MaxBytes returns the longest QR payload that prints in dots printer dots
+// with these options
+func (o QROptions) MaxBytes(dots int) int {
+	return QRCapacity(o.MaxVersion(dots), o.ErrorCorrection)
+}
@@ -0,0 +1,142 @@
+package escpos
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Real-time status requests (DLE EOT n). The printer answers each one with
+// a single byte as soon as it is received, even while offline.
+const (
+	StatusPrinter byte = 1 // Online or offline
+	StatusOffline byte = 2 // Why the printer is offline: cover, paper end
+	StatusError   byte = 3 // Cutter and other errors
+	StatusPaper   byte = 4 // Roll paper sensors
+)
+
+// StatusRequest returns the real-time status request DLE EOT n
+func StatusRequest(n byte) []byte {
+	return []byte{DLE, EOT, n}
+}
+
+// Status is the printer state reported by the DLE EOT replies
+type Status struct {
+	Online        bool `json:"online"`
+	CoverOpen     bool `json:"cover_open"`
+	PaperNearEnd  bool `json:"paper_near_end"`
+	PaperOut      bool `json:"paper_out"`
+	CutterError   bool `json:"cutter_error"`
+	Unrecoverable bool `json:"unrecoverable_error"` // Needs a power cycle
+	Recoverable   bool `json:"recoverable_error"`   // Clears by itself, e.g. print head too hot
+}
+
+// IsStatusReply reports whether b has the fixed bits of a DLE EOT reply
+// (0xx1xx10), which tells it apart from other bytes the printer may send
+func IsStatusReply(b byte) bool {
+	return b&0x93 == 0x12
+}
+
+// Parse applies the reply b to the request DLE EOT n
+func (s *Status) Parse(n, b byte) error {
+	if !IsStatusReply(b) {
+		return fmt.Errorf("invalid reply %#02x to DLE EOT %d", b, n)
+	}
+
+	switch n {
+	case StatusPrinter:
+		s.Online = b&0x08 == 0
+	case StatusOffline:
+		s.CoverOpen = b&0x04 != 0
+		// Printing stopped because the paper ran out
+		s.PaperOut = s.PaperOut || b&0x20 != 0
+	case StatusError:
+		s.CutterError = b&0x08 != 0
+		s.Unrecoverable = b&0x20 != 0
+		s.Recoverable = b&0x40 != 0
+	case StatusPaper:
+		s.PaperNearEnd = b&0x0C != 0
+		s.PaperOut = s.PaperOut || b&0x60 != 0
+	default:
+		return fmt.Errorf("unknown status request %d", n)
+	}
+	return nil
+}
+
+// Ready reports whether the printer can print
+func (s Status) Ready() bool {
+	return s.Online && !s.CoverOpen && !s.PaperOut && !s.CutterError && !s.Unrecoverable
+}
+
+// String lists the problems, e.g. "offline, cover open", or "ready"
+func (s Status) String() string {
+	var problems []string
+	if !s.Online {
+		problems = append(problems, "offline")
+	}
+	if s.CoverOpen {
+		problems = append(problems, "cover open")
+	}
+	if s.PaperOut {
+		problems = append(problems, "paper out")
+	} else if s.PaperNearEnd {
+		problems = append(problems, "paper near end")
+	}
+	if s.CutterError {
+		problems = append(problems, "cutter error")
+	}
+	if s.Unrecoverable {
+		problems = append(problems, "unrecoverable error")
+	}
+	if s.Recoverable {
+		problems = append(problems, "recoverable error")
+	}
+	if len(problems) == 0 {
+		return "ready"
+	}
+	return strings.Join(problems, ", ")
+}
+
+// ASB bits for EnableASB (GS a n)
+const (
+	ASBDrawer  byte = 0x01 // Drawer kick-out connector
+	ASBOnline  byte = 0x02 // Online/offline, cover, feed button
+	ASBError   byte = 0x04 // Cutter and other errors
+	ASBPaper   byte = 0x08 // Roll paper sensors
+	ASBDefault      = ASBOnline | ASBError | ASBPaper
+)
+
+// EnableASB turns automatic status back on for the given ASB bits, or off
+// for 0 (GS a n). The printer then sends a 4-byte status block right away
+// and again whenever one of the selected statuses changes.
+func EnableASB(n byte) []byte {
+	return []byte{GS, 'a', n}
+}
+
+// IsASBHeader reports whether b can be the first byte of an ASB block
+// (0xx1xx00)
+func IsASBHeader(b byte) bool {
+	return b&0x93 == 0x10
+}
+
+// IsASBBody reports whether b can be one of the last three bytes of an ASB
+// block (0xx0xxxx)
+func IsASBBody(b byte) bool {
+	return b&0x90 == 0
+}
+
+// ParseASB decodes a 4-byte automatic status back block
+func ParseASB(block [4]byte) (Status, error) {
+	if !IsASBHeader(block[0]) || !IsASBBody(block[1]) || !IsASBBody(block[2]) || !IsASBBody(block[3]) {
+		return Status{}, fmt.Errorf("invalid status block % X", block[:])
+	}
+
+	return Status{
+		Online:        block[0]&0x08 == 0,
+		CoverOpen:     block[0]&0x20 != 0,
+		CutterError:   block[1]&0x08 != 0,
+		Unrecoverable: block[1]&0x20 != 0,
+		Recoverable:   block[1]&0x40 != 0,
+		PaperNearEnd:  block[2]&0x03 != 0,
+		PaperOut:      block[2]&0x0C != 0,
+	}, nil
+}
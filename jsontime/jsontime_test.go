@@ -0,0 +1,44 @@
+package jsontime
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestDurationUnmarshal(t *testing.T) {
+	tests := []struct {
+		in   string
+		want time.Duration
+	}{
+		{`"5s"`, 5 * time.Second},
+		{`"1m30s"`, 90 * time.Second},
+		{`2500`, 2500 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		var d Duration
+		if err := json.Unmarshal([]byte(tt.in), &d); err != nil {
+			t.Fatalf("%s: %v", tt.in, err)
+		}
+		if time.Duration(d) != tt.want {
+			t.Errorf("%s = %v, want %v", tt.in, time.Duration(d), tt.want)
+		}
+	}
+
+	for _, in := range []string{`"soon"`, `true`} {
+		var d Duration
+		if err := json.Unmarshal([]byte(in), &d); err == nil {
+			t.Errorf("%s: want error", in)
+		}
+	}
+}
+
+func TestDurationMarshal(t *testing.T) {
+	data, err := json.Marshal(Duration(10 * time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `"10m0s"` {
+		t.Errorf("got %s", data)
+	}
+}
@@ -0,0 +1,638 @@
+// Package queue keeps print jobs on disk until the printer has accepted
+// them, so a printer that dozed off or was power-cycled no longer means a
+// lost receipt.
+//
+// Each job is one JSON file in the queue directory. A single worker drains
+// the jobs in order and retries failures with exponential backoff.
+package queue
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"cleanlink/printer/render"
+)
+
+// Job states
+const (
+	StateQueued   = "queued"   // Waiting for the printer, possibly between retries
+	StatePrinting = "printing" // Being sent to the printer
+	StatePrinted  = "printed"  // Accepted by the printer
+	StateFailed   = "failed"   // Gave up after MaxAttempts
+)
+
+// Defaults for Options
+const (
+	DefaultMaxAttempts = 10
+	DefaultBackoff     = 2 * time.Second
+	DefaultMaxBackoff  = 5 * time.Minute
+	DefaultRetention   = 7 * 24 * time.Hour
+)
+
+// pruneInterval is how often Run removes expired jobs, shortened in tests
+var pruneInterval = time.Hour
+
+// ErrNotFound is returned for unknown job IDs
+var ErrNotFound = errors.New("job not found")
+
+// ErrPaused is returned by a Handler that printed what it could of a job
+// and left the rest for paused printers. It does not count as an attempt.
+var ErrPaused = errors.New("printer paused")
+
+// Job is a print request and its progress
+type Job struct {
+	ID      string              `json:"id"`
+	Key     string              `json:"key,omitempty"` // Idempotency key
+	Request render.PrintRequest `json:"request"`
+
+	ReprintOf string `json:"reprint_of,omitempty"` // ID of the job this is a copy of
+
+	State     string    `json:"state"`
+	Attempts  int       `json:"attempts"`
+	LastError string    `json:"last_error,omitempty"`
+	ErrorCode string    `json:"error_code,omitempty"` // Code of LastError, e.g. "PRINTER_OFFLINE"
+	Printer   string    `json:"printer,omitempty"`    // Address of the printer that accepted the job
+	NextTry   time.Time `json:"next_try,omitzero"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	PrintedAt time.Time `json:"printed_at,omitzero"`
+
+	// Parts is the progress of each piece of a job split across printers,
+	// e.g. the receipt and the QR labels of an "all" job
+	Parts []Part `json:"parts,omitempty"`
+}
+
+// Part is one piece of a job and its progress on the printer it is routed
+// to. Only parts that are not printed yet are tried again.
+type Part struct {
+	Mode      string    `json:"mode"`    // Print mode of the piece, e.g. "qr-only"
+	Printer   string    `json:"printer"` // Name of the printer it is routed to
+	State     string    `json:"state"`   // queued, printed or failed
+	Address   string    `json:"address,omitempty"`
+	LastError string    `json:"last_error,omitempty"`
+	ErrorCode string    `json:"error_code,omitempty"`
+	PrintedAt time.Time `json:"printed_at,omitzero"`
+}
+
+// Part returns the progress of the piece of job printed in mode
+func (j Job) Part(mode string) (Part, bool) {
+	for _, p := range j.Parts {
+		if p.Mode == mode {
+			return p, true
+		}
+	}
+	return Part{}, false
+}
+
+// Handler sends a job to the printer and returns the printer's address
+type Handler func(job Job) (printer string, err error)
+
+// Printers returns the names of the printers a job has left to print on,
+// so Run can hold it back while they are paused
+type Printers func(job Job) []string
+
+// Options configures a Queue
+type Options struct {
+	// Dir stores the jobs; empty keeps them in memory only
+	Dir string
+	// MaxAttempts is how often a job is tried before it fails
+	MaxAttempts int
+	// Backoff is the delay after the first failure, doubled after each
+	// further failure up to MaxBackoff
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// Retention is how long printed and failed jobs are kept
+	Retention time.Duration
+}
+
+// Queue is a persistent FIFO of print jobs
+type Queue struct {
+	opts Options
+
+	mu     sync.Mutex
+	jobs   map[string]*Job
+	wake   chan struct{}
+	paused map[string]string // Why each paused printer cannot print
+
+	now func() time.Time
+}
+
+// Open loads the jobs stored in opts.Dir. Jobs that were printing when the
+// agent stopped are queued again.
+func Open(opts Options) (*Queue, error) {
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = DefaultMaxAttempts
+	}
+	if opts.Backoff <= 0 {
+		opts.Backoff = DefaultBackoff
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = DefaultMaxBackoff
+	}
+	if opts.Retention <= 0 {
+		opts.Retention = DefaultRetention
+	}
+
+	q := &Queue{
+		opts: opts,
+		jobs: map[string]*Job{},
+		wake: make(chan struct{}, 1),
+		now:  time.Now,
+	}
+	if opts.Dir == "" {
+		return q, nil
+	}
+
+	if err := os.MkdirAll(opts.Dir, 0755); err != nil {
+		return nil, fmt.Errorf("create queue dir: %w", err)
+	}
+	if err := q.load(); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+func (q *Queue) load() error {
+	entries, err := os.ReadDir(q.opts.Dir)
+	if err != nil {
+		return fmt.Errorf("read queue dir: %w", err)
+	}
+
+	now := q.now()
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		path := filepath.Join(q.opts.Dir, e.Name())
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("read job: %w", err)
+		}
+		var job Job
+		if err := json.Unmarshal(data, &job); err != nil || job.ID == "" {
+			fmt.Printf("[QUEUE] Skipping unreadable job file %s: %v\n", e.Name(), err)
+			continue
+		}
+
+		switch {
+		case q.expired(&job, now):
+			os.Remove(path)
+			continue
+		case job.State == StatePrinting:
+			// The agent stopped mid-job; the printer may or may not have
+			// received it, a duplicate beats a lost receipt
+			job.State = StateQueued
+			job.NextTry = time.Time{}
+			if err := q.save(&job); err != nil {
+				return err
+			}
+		}
+		q.jobs[job.ID] = &job
+	}
+	return nil
+}
+
+// expired reports whether job is printed or failed and older than Retention
+func (q *Queue) expired(job *Job, now time.Time) bool {
+	done := job.State == StatePrinted || job.State == StateFailed
+	return done && now.Sub(job.UpdatedAt) > q.opts.Retention
+}
+
+// prune removes the expired jobs, so an agent that runs for weeks does not
+// keep every job it ever printed
+func (q *Queue) prune() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := q.now()
+	for id, job := range q.jobs {
+		if !q.expired(job, now) {
+			continue
+		}
+		delete(q.jobs, id)
+		if q.opts.Dir != "" {
+			if err := os.Remove(filepath.Join(q.opts.Dir, id+".json")); err != nil && !errors.Is(err, os.ErrNotExist) {
+				fmt.Println("[QUEUE] Cannot remove job:", err)
+			}
+		}
+	}
+}
+
+// save writes job to disk atomically. Callers hold q.mu or own job.
+func (q *Queue) save(job *Job) error {
+	if q.opts.Dir == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(job, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(q.opts.Dir, job.ID+".json")
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("save job: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("save job: %w", err)
+	}
+	return nil
+}
+
+// Enqueue stores req as a new job and wakes the worker. The token is never
+// written to disk.
+func (q *Queue) Enqueue(req render.PrintRequest) (Job, error) {
+	job, _, err := q.EnqueueUnique("", 0, req)
+	return job, err
+}
+
+// EnqueueUnique is like Enqueue, but if a job with the same key was created
+// within window it returns that job and true instead. Failed jobs are
+// ignored so a retry after a failure prints again.
+func (q *Queue) EnqueueUnique(key string, window time.Duration, req render.PrintRequest) (Job, bool, error) {
+	req.Token = ""
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := q.now()
+	if key != "" && window > 0 {
+		if dup := q.findKey(key, now.Add(-window)); dup != nil {
+			return *dup, true, nil
+		}
+	}
+
+	job := &Job{
+		ID:        newID(now),
+		Key:       key,
+		Request:   req,
+		State:     StateQueued,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if err := q.save(job); err != nil {
+		return Job{}, false, err
+	}
+	q.jobs[job.ID] = job
+
+	q.signal()
+	return *job, false, nil
+}
+
+// EnqueueReprint queues req as a copy of the job with the given ID
+func (q *Queue) EnqueueReprint(id string, req render.PrintRequest) (Job, error) {
+	req.Token = ""
+	req.Reprint = true
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if _, ok := q.jobs[id]; !ok {
+		return Job{}, ErrNotFound
+	}
+
+	now := q.now()
+	job := &Job{
+		ID:        newID(now),
+		Request:   req,
+		ReprintOf: id,
+		State:     StateQueued,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if err := q.save(job); err != nil {
+		return Job{}, err
+	}
+	q.jobs[job.ID] = job
+
+	q.signal()
+	return *job, nil
+}
+
+// findKey returns the newest job with key created after since
+func (q *Queue) findKey(key string, since time.Time) *Job {
+	var found *Job
+	for _, job := range q.jobs {
+		if job.Key != key || job.State == StateFailed || job.CreatedAt.Before(since) {
+			continue
+		}
+		if found == nil || compareJobs(*job, *found) > 0 {
+			found = job
+		}
+	}
+	return found
+}
+
+// Get returns the job with the given ID
+func (q *Queue) Get(id string) (Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	job, ok := q.jobs[id]
+	if !ok {
+		return Job{}, ErrNotFound
+	}
+	return *job, nil
+}
+
+// SetPart records the outcome of sending one piece of the job with the
+// given ID to its printer: printed if err is nil, otherwise it is tried
+// again with the job
+func (q *Queue) SetPart(id string, part Part, err error) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	job, ok := q.jobs[id]
+	if !ok {
+		return ErrNotFound
+	}
+
+	part.State, part.PrintedAt = StatePrinted, q.now()
+	part.LastError, part.ErrorCode = errorFields(err)
+	if err != nil {
+		part.State, part.PrintedAt = StateQueued, time.Time{}
+	}
+
+	i := slices.IndexFunc(job.Parts, func(p Part) bool { return p.Mode == part.Mode })
+	if i < 0 {
+		job.Parts = append(job.Parts, part)
+	} else {
+		job.Parts[i] = part
+	}
+	job.UpdatedAt = q.now()
+	return q.save(job)
+}
+
+// Jobs returns the jobs for which keep returns true, oldest first. A nil
+// keep returns every job.
+func (q *Queue) Jobs(keep func(Job) bool) []Job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var out []Job
+	for _, job := range q.jobs {
+		if keep == nil || keep(*job) {
+			out = append(out, *job)
+		}
+	}
+	slices.SortFunc(out, compareJobs)
+	return out
+}
+
+// Run sends queued jobs to handle one at a time until ctx is cancelled.
+// A job being printed when ctx is cancelled is finished first. Printed and
+// failed jobs are removed once they are older than Retention. Jobs whose
+// printers are all paused wait; printers may be nil if no job does.
+func (q *Queue) Run(ctx context.Context, handle Handler, printers Printers) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	prune := time.NewTicker(pruneInterval)
+	defer prune.Stop()
+
+	for ctx.Err() == nil {
+		select {
+		case <-prune.C:
+			q.prune()
+		default:
+		}
+
+		job, wait := q.next(printers)
+		if job != nil {
+			q.process(job, handle)
+			continue
+		}
+
+		if wait > 0 {
+			timer.Reset(wait)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-q.wake:
+		case <-timer.C:
+		case <-prune.C:
+			q.prune()
+		}
+		timer.Stop()
+	}
+}
+
+// Requeue puts jobs that are printing back in the queue and returns their
+// IDs. It is used when the agent stops before the printer finished; the
+// jobs print again on the next start, since a duplicate beats a lost
+// receipt.
+func (q *Queue) Requeue() []string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var ids []string
+	for _, job := range q.jobs {
+		if job.State != StatePrinting {
+			continue
+		}
+		job.State = StateQueued
+		job.NextTry = time.Time{}
+		job.UpdatedAt = q.now()
+		if err := q.save(job); err != nil {
+			fmt.Println("[QUEUE] Cannot save job:", err)
+		}
+		ids = append(ids, job.ID)
+	}
+	return ids
+}
+
+// Pause stops Run from starting jobs for the named printer until Resume,
+// e.g. while it reports that it is out of paper. Queued jobs keep their
+// attempts.
+func (q *Queue) Pause(printer, reason string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.paused[printer] == "" {
+		fmt.Printf("[QUEUE] Paused %s: %s\n", printer, reason)
+	}
+	if q.paused == nil {
+		q.paused = map[string]string{}
+	}
+	q.paused[printer] = reason
+}
+
+// Resume lets Run start jobs for the named printer again. Jobs waiting for
+// a retry are tried right away, since the printer just became ready.
+func (q *Queue) Resume(printer string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.paused[printer] == "" {
+		return
+	}
+	delete(q.paused, printer)
+	for _, job := range q.jobs {
+		if job.State == StateQueued {
+			job.NextTry = time.Time{}
+		}
+	}
+	fmt.Printf("[QUEUE] Resumed %s\n", printer)
+	q.signal()
+}
+
+// Paused returns why the named printer is paused, or "" if it is not
+func (q *Queue) Paused(printer string) string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.paused[printer]
+}
+
+// waiting reports whether every printer the job has left to print on is
+// paused. A job with a piece for a printer that is ready goes ahead, and
+// its Handler leaves the other pieces for later.
+func (q *Queue) waiting(job Job, printers Printers) bool {
+	if printers == nil {
+		return false
+	}
+	names := printers(job)
+	for _, name := range names {
+		if q.paused[name] == "" {
+			return false
+		}
+	}
+	return len(names) > 0
+}
+
+// next returns the oldest queued job if it is due. Otherwise it returns how
+// long until it is, or 0 if there is nothing to do. Jobs are printed in
+// order, so a job waiting for a retry holds back the ones behind it, but a
+// job waiting for paused printers does not.
+func (q *Queue) next(printers Printers) (*Job, time.Duration) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var head *Job
+	for _, job := range q.jobs {
+		if job.State != StateQueued || (head != nil && compareJobs(*job, *head) >= 0) {
+			continue
+		}
+		if !q.waiting(*job, printers) {
+			head = job
+		}
+	}
+	if head == nil {
+		return nil, 0
+	}
+
+	if wait := head.NextTry.Sub(q.now()); wait > 0 {
+		return nil, wait
+	}
+
+	head.State = StatePrinting
+	head.Attempts++
+	head.UpdatedAt = q.now()
+	if err := q.save(head); err != nil {
+		fmt.Println("[QUEUE] Cannot save job:", err)
+	}
+	return head, 0
+}
+
+func (q *Queue) process(job *Job, handle Handler) {
+	q.mu.Lock()
+	snapshot := *job
+	q.mu.Unlock()
+
+	printer, err := handle(snapshot)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := q.now()
+	job.UpdatedAt = now
+	if printer != "" {
+		job.Printer = printer
+	}
+
+	job.LastError, job.ErrorCode = errorFields(err)
+
+	switch {
+	case err == nil:
+		job.State = StatePrinted
+		job.PrintedAt = now
+		fmt.Printf("[QUEUE] Job %s printed on %s (attempt %d)\n", job.ID, printer, job.Attempts)
+	case errors.Is(err, ErrPaused):
+		// Only pieces for paused printers are left, they print on Resume
+		job.Attempts--
+		job.State = StateQueued
+		fmt.Printf("[QUEUE] Job %s waiting: %v\n", job.ID, err)
+	case job.Attempts >= q.opts.MaxAttempts:
+		job.State = StateFailed
+		for i := range job.Parts {
+			if job.Parts[i].State != StatePrinted {
+				job.Parts[i].State = StateFailed
+			}
+		}
+		fmt.Printf("[QUEUE] Job %s failed after %d attempts: %v\n", job.ID, job.Attempts, err)
+	default:
+		delay := q.backoff(job.Attempts)
+		job.State = StateQueued
+		job.NextTry = now.Add(delay)
+		fmt.Printf("[QUEUE] Job %s attempt %d failed, retrying in %s: %v\n", job.ID, job.Attempts, delay, err)
+	}
+
+	if err := q.save(job); err != nil {
+		fmt.Println("[QUEUE] Cannot save job:", err)
+	}
+}
+
+// errorFields returns the message and, for coded errors, the code of err
+func errorFields(err error) (message, code string) {
+	if err == nil {
+		return "", ""
+	}
+	var coded interface{ ErrorCode() string }
+	if errors.As(err, &coded) {
+		code = coded.ErrorCode()
+	}
+	return err.Error(), code
+}
+
+// backoff returns the delay after the given number of failed attempts
+func (q *Queue) backoff(attempts int) time.Duration {
+	d := q.opts.Backoff
+	for i := 1; i < attempts && d < q.opts.MaxBackoff; i++ {
+		d *= 2
+	}
+	return min(d, q.opts.MaxBackoff)
+}
+
+func (q *Queue) signal() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+func compareJobs(a, b Job) int {
+	if c := a.CreatedAt.Compare(b.CreatedAt); c != 0 {
+		return c
+	}
+	return strings.Compare(a.ID, b.ID)
+}
+
+// newID returns a sortable, unique job ID such as "20251222-164501-3f9a1c2e"
+func newID(now time.Time) string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return now.UTC().Format("20060102-150405") + "-" + hex.EncodeToString(b)
+}
@@ -0,0 +1,368 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"cleanlink/printer/render"
+)
+
+// run drains q with handle until every job is printed or failed
+func run(t *testing.T, q *Queue, handle Handler) {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		q.Run(ctx, handle, nil)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		pending := q.Jobs(func(j Job) bool {
+			return j.State == StateQueued || j.State == StatePrinting
+		})
+		if len(pending) == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d jobs still pending", len(pending))
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestJobsSurviveRestart(t *testing.T) {
+	dir := t.TempDir()
+
+	q, err := Open(Options{Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	job, err := q.Enqueue(render.PrintRequest{Token: "secret", OrderID: "ORD-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, job.ID+".json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "secret") {
+		t.Error("token written to disk")
+	}
+
+	// Reopen as if the agent had restarted
+	q, err = Open(Options{Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := q.Get(job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.State != StateQueued || got.Request.OrderID != "ORD-1" {
+		t.Errorf("unexpected job after restart %+v", got)
+	}
+}
+
+func TestInterruptedJobIsRequeued(t *testing.T) {
+	dir := t.TempDir()
+
+	q, _ := Open(Options{Dir: dir})
+	job, _ := q.Enqueue(render.PrintRequest{OrderID: "ORD-1"})
+	if j, _ := q.next(nil); j == nil || j.ID != job.ID {
+		t.Fatal("job not picked up")
+	}
+
+	q, _ = Open(Options{Dir: dir})
+	got, _ := q.Get(job.ID)
+	if got.State != StateQueued || got.Attempts != 1 {
+		t.Errorf("unexpected job after restart %+v", got)
+	}
+}
+
+func TestRunInOrder(t *testing.T) {
+	q, _ := Open(Options{})
+
+	var ids []string
+	for range 5 {
+		job, _ := q.Enqueue(render.PrintRequest{})
+		ids = append(ids, job.ID)
+	}
+
+	var mu sync.Mutex
+	var printed []string
+	run(t, q, func(job Job) (string, error) {
+		mu.Lock()
+		printed = append(printed, job.ID)
+		mu.Unlock()
+		return "COM10", nil
+	})
+
+	if strings.Join(printed, ",") != strings.Join(ids, ",") {
+		t.Errorf("printed %v, want %v", printed, ids)
+	}
+	for _, id := range ids {
+		job, _ := q.Get(id)
+		if job.State != StatePrinted || job.Printer != "COM10" || job.PrintedAt.IsZero() {
+			t.Errorf("unexpected job %+v", job)
+		}
+	}
+}
+
+func TestRetryThenFail(t *testing.T) {
+	q, _ := Open(Options{MaxAttempts: 3, Backoff: time.Millisecond})
+	job, _ := q.Enqueue(render.PrintRequest{})
+
+	calls := 0
+	run(t, q, func(Job) (string, error) {
+		calls++
+		return "COM10", errors.New("printer asleep")
+	})
+
+	got, _ := q.Get(job.ID)
+	if got.State != StateFailed || got.Attempts != 3 || got.LastError != "printer asleep" {
+		t.Errorf("unexpected job %+v", got)
+	}
+	if calls != 3 {
+		t.Errorf("handler called %d times, want 3", calls)
+	}
+}
+
+func TestParts(t *testing.T) {
+	q, _ := Open(Options{MaxAttempts: 2, Backoff: time.Millisecond})
+	job, _ := q.Enqueue(render.PrintRequest{PrintMode: render.ModeAll})
+
+	receipts := 0
+	run(t, q, func(job Job) (string, error) {
+		if p, _ := job.Part(render.ModeReceiptOnly); p.State != StatePrinted {
+			receipts++
+			q.SetPart(job.ID, Part{Mode: render.ModeReceiptOnly, Printer: "counter", Address: "COM10"}, nil)
+		}
+		err := errors.New("label printer asleep")
+		q.SetPart(job.ID, Part{Mode: render.ModeQROnly, Printer: "labels", Address: "192.168.1.50:9100"}, err)
+		return "COM10", err
+	})
+
+	if receipts != 1 {
+		t.Errorf("receipt printed %d times, want 1", receipts)
+	}
+	got, _ := q.Get(job.ID)
+	receipt, _ := got.Part(render.ModeReceiptOnly)
+	labels, _ := got.Part(render.ModeQROnly)
+	if got.State != StateFailed || len(got.Parts) != 2 {
+		t.Fatalf("unexpected job %+v", got)
+	}
+	if receipt.State != StatePrinted || receipt.PrintedAt.IsZero() || receipt.Printer != "counter" {
+		t.Errorf("unexpected receipt part %+v", receipt)
+	}
+	if labels.State != StateFailed || labels.LastError != "label printer asleep" || !labels.PrintedAt.IsZero() {
+		t.Errorf("unexpected labels part %+v", labels)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	q, _ := Open(Options{Backoff: time.Second, MaxBackoff: 10 * time.Second})
+
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second}
+	for i, w := range want {
+		if got := q.backoff(i + 1); got != w {
+			t.Errorf("backoff(%d) = %s, want %s", i+1, got, w)
+		}
+	}
+}
+
+func TestOldJobsArePruned(t *testing.T) {
+	dir := t.TempDir()
+
+	q, _ := Open(Options{Dir: dir, Retention: time.Hour})
+	job, _ := q.Enqueue(render.PrintRequest{})
+	run(t, q, func(Job) (string, error) { return "COM10", nil })
+
+	q, _ = Open(Options{Dir: dir, Retention: time.Hour})
+	if _, err := q.Get(job.ID); err != nil {
+		t.Fatal("recent job pruned")
+	}
+
+	q.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	if err := q.load(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, job.ID+".json")); !os.IsNotExist(err) {
+		t.Error("expired job file not removed")
+	}
+}
+
+func TestOldJobsArePrunedWhileRunning(t *testing.T) {
+	interval := pruneInterval
+	t.Cleanup(func() { pruneInterval = interval })
+	pruneInterval = 5 * time.Millisecond
+
+	dir := t.TempDir()
+	q, _ := Open(Options{Dir: dir, Retention: time.Hour})
+	var later atomic.Int64
+	q.now = func() time.Time { return time.Now().Add(time.Duration(later.Load())) }
+
+	job, _ := q.Enqueue(render.PrintRequest{})
+	queued, _ := q.Enqueue(render.PrintRequest{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		q.Run(ctx, func(j Job) (string, error) {
+			if j.ID == queued.ID {
+				return "", errors.New("printer asleep")
+			}
+			return "COM10", nil
+		}, nil)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	waitFor := func(what string, cond func() bool) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for !cond() {
+			if time.Now().After(deadline) {
+				t.Fatal(what)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+	waitFor("job never printed", func() bool {
+		j, _ := q.Get(job.ID)
+		return j.State == StatePrinted
+	})
+	time.Sleep(20 * time.Millisecond)
+	if _, err := q.Get(job.ID); err != nil {
+		t.Fatal("recent job pruned")
+	}
+
+	// Two hours later, without reopening the queue
+	later.Store(int64(2 * time.Hour))
+	waitFor("expired job not pruned", func() bool {
+		_, err := q.Get(job.ID)
+		return errors.Is(err, ErrNotFound)
+	})
+	if _, err := os.Stat(filepath.Join(dir, job.ID+".json")); !os.IsNotExist(err) {
+		t.Error("expired job file not removed")
+	}
+	if _, err := q.Get(queued.ID); err != nil {
+		t.Error("queued job pruned")
+	}
+}
+
+func TestEnqueueUnique(t *testing.T) {
+	q, _ := Open(Options{})
+	start := time.Now()
+	q.now = func() time.Time { return start }
+
+	first, dup, _ := q.EnqueueUnique("k", time.Minute, render.PrintRequest{OrderID: "ORD-1"})
+	if dup {
+		t.Fatal("first job reported as duplicate")
+	}
+	if job, dup, _ := q.EnqueueUnique("k", time.Minute, render.PrintRequest{}); !dup || job.ID != first.ID {
+		t.Errorf("duplicate not detected: %+v", job)
+	}
+
+	// Outside the window the key prints again
+	q.now = func() time.Time { return start.Add(2 * time.Minute) }
+	second, dup, _ := q.EnqueueUnique("k", time.Minute, render.PrintRequest{})
+	if dup || second.ID == first.ID {
+		t.Error("job outside the window treated as duplicate")
+	}
+
+	// A failed job does not block a retry
+	q.mu.Lock()
+	q.jobs[second.ID].State = StateFailed
+	q.mu.Unlock()
+	if _, dup, _ := q.EnqueueUnique("k", time.Minute, render.PrintRequest{}); dup {
+		t.Error("failed job treated as duplicate")
+	}
+}
+
+func TestRequeue(t *testing.T) {
+	dir := t.TempDir()
+	q, _ := Open(Options{Dir: dir})
+	job, _ := q.Enqueue(render.PrintRequest{OrderID: "ORD-1"})
+	waiting, _ := q.Enqueue(render.PrintRequest{OrderID: "ORD-2"})
+	q.next(nil)
+
+	if ids := q.Requeue(); len(ids) != 1 || ids[0] != job.ID {
+		t.Fatalf("Requeue() = %v, want [%s]", ids, job.ID)
+	}
+
+	q, _ = Open(Options{Dir: dir})
+	for _, id := range []string{job.ID, waiting.ID} {
+		if got, _ := q.Get(id); got.State != StateQueued {
+			t.Errorf("job %s is %s after restart, want queued", id, got.State)
+		}
+	}
+}
+
+func TestPause(t *testing.T) {
+	q, _ := Open(Options{})
+	receipt, _ := q.Enqueue(render.PrintRequest{OrderID: "ORD-1"})
+	label, _ := q.Enqueue(render.PrintRequest{OrderID: "ORD-2"})
+	printers := func(j Job) []string {
+		if j.ID == label.ID {
+			return []string{"labels"}
+		}
+		return []string{"default"}
+	}
+
+	q.Pause("default", "paper out")
+	if q.Paused("default") != "paper out" || q.Paused("labels") != "" {
+		t.Errorf("Paused() = %q, %q", q.Paused("default"), q.Paused("labels"))
+	}
+
+	// A job for another printer is not held back by the paused one
+	if j, _ := q.next(printers); j == nil || j.ID != label.ID {
+		t.Fatalf("next() = %+v, want the label job", j)
+	}
+	if j, _ := q.next(printers); j != nil {
+		t.Fatal("paused printer started a job")
+	}
+
+	// A job waiting for a retry is tried as soon as its printer resumes
+	q.mu.Lock()
+	q.jobs[receipt.ID].NextTry = time.Now().Add(time.Hour)
+	q.mu.Unlock()
+	q.Resume("default")
+	if j, _ := q.next(printers); j == nil || j.ID != receipt.ID || j.Attempts != 1 {
+		t.Errorf("job not started after Resume: %+v", j)
+	}
+}
+
+func TestPausedPartIsNoAttempt(t *testing.T) {
+	q, _ := Open(Options{Backoff: time.Hour})
+	job, _ := q.Enqueue(render.PrintRequest{})
+	q.Pause("labels", "paper out")
+
+	j, _ := q.next(nil)
+	q.process(j, func(Job) (string, error) {
+		return "COM10", fmt.Errorf("qr-only: %w", ErrPaused)
+	})
+
+	got, _ := q.Get(job.ID)
+	if got.State != StateQueued || got.Attempts != 0 || !got.NextTry.IsZero() {
+		t.Errorf("job after a paused part = %+v", got)
+	}
+}
@@ -0,0 +1,287 @@
+// Package render turns a PrintRequest into the ESC/POS byte stream sent to
+// the printer. Every agent uses the same renderer so the POS frontend gets
+// identical receipts regardless of which agent a branch installed.
+package render
+
+import (
+	"strings"
+
+	"cleanlink/printer/escpos"
+)
+
+// Print modes
+const (
+	ModeAll         = "all"          // Receipt, staff separator, then all QR labels
+	ModeReceiptOnly = "receipt-only" // Customer receipt only
+	ModeQROnly      = "qr-only"      // QR code labels only (for staff)
+	ModeLabel       = "label"        // QR labels headed by the branch name
+	ModeSeparator   = "separator"    // Only the "Untuk Staff" barrier
+)
+
+// ================= STRUCT =================
+
+type QRCodeData struct {
+	ServiceName string `json:"service_name"`
+	OrderID     string `json:"order_id"`
+	Body        string `json:"body"`
+	QRValue     string `json:"qr_value"`
+}
+
+type PrintRequest struct {
+	Token      string       `json:"token"`
+	Title      string       `json:"title"`
+	OrderID    string       `json:"order_id"`
+	Body       string       `json:"body"`
+	QRValue    string       `json:"qr_value"`     // Deprecated: use QRCodes array instead
+	PrintMode  string       `json:"print_mode"`   // "all", "receipt-only", "qr-only", "label", "separator"
+	QRCodes    []QRCodeData `json:"qr_codes"`     // Array of QR codes to print
+	NoPaperCut bool         `json:"no_paper_cut"` // Deprecated: not needed anymore
+
+	ForceReprint bool `json:"force_reprint,omitempty"` // Print even if an identical job was just submitted
+	Reprint      bool `json:"reprint,omitempty"`       // Mark the copy with a REPRINT banner, set by the agent only
+}
+
+// ValidMode reports whether mode is a known print mode
+func ValidMode(mode string) bool {
+	switch mode {
+	case ModeAll, ModeReceiptOnly, ModeQROnly, ModeLabel, ModeSeparator:
+		return true
+	}
+	return false
+}
+
+// Mode returns the print mode for req. When no mode is given, requests
+// with QR codes print everything and the rest print the receipt only.
+func (req PrintRequest) Mode() string {
+	if req.PrintMode != "" {
+		return req.PrintMode
+	}
+	if len(req.QRCodes) > 0 || req.QRValue != "" {
+		return ModeAll
+	}
+	return ModeReceiptOnly
+}
+
+// Parts splits req into the pieces that can print on different printers:
+// "all" is the customer receipt and the QR labels, every other mode is a
+// single piece. Each piece renders on its own, from initialization to the
+// paper cut, and without the staff separator between them.
+func (req PrintRequest) Parts() []PrintRequest {
+	if req.Mode() != ModeAll {
+		return []PrintRequest{req}
+	}
+	receipt := req
+	receipt.PrintMode = ModeReceiptOnly
+	if len(req.labels()) == 0 {
+		return []PrintRequest{receipt}
+	}
+	labels := req
+	labels.PrintMode = ModeQROnly
+	return []PrintRequest{receipt, labels}
+}
+
+// labels returns the QR labels to print, falling back to the deprecated
+// single QR value for older POS versions
+func (req PrintRequest) labels() []QRCodeData {
+	if len(req.QRCodes) > 0 {
+		return req.QRCodes
+	}
+	if req.QRValue != "" {
+		return []QRCodeData{{
+			ServiceName: req.Title,
+			OrderID:     req.OrderID,
+			Body:        req.Body,
+			QRValue:     req.QRValue,
+		}}
+	}
+	return nil
+}
+
+// titledLabels returns the labels of req headed by the branch name instead
+// of the service name
+func (req PrintRequest) titledLabels() []QRCodeData {
+	labels := append([]QRCodeData(nil), req.labels()...)
+	for i := range labels {
+		labels[i].ServiceName = req.Title
+	}
+	return labels
+}
+
+// ================= RENDERER =================
+
+// Options controls the receipt layout
+type Options struct {
+	// Width is the number of characters per line (32 for 57mm paper)
+	Width int
+	// QR controls the size and error correction of QR labels
+	QR escpos.QROptions
+}
+
+// DefaultOptions are tuned for 57mm paper
+var DefaultOptions = Options{Width: 32, QR: escpos.DefaultQR}
+
+// Renderer lays out print requests
+type Renderer struct {
+	opts Options
+}
+
+// New returns a renderer using opts
+func New(opts Options) *Renderer {
+	if opts.Width <= 0 {
+		opts.Width = DefaultOptions.Width
+	}
+	if opts.QR.Size == 0 {
+		opts.QR = DefaultOptions.QR
+	}
+	return &Renderer{opts: opts}
+}
+
+// Options returns the layout the renderer uses
+func (r *Renderer) Options() Options {
+	return r.opts
+}
+
+// Render renders req with DefaultOptions
+func Render(req PrintRequest) []byte {
+	return New(DefaultOptions).Render(req)
+}
+
+// Render returns the complete byte stream for req, from printer
+// initialization to the final paper cut
+func (r *Renderer) Render(req PrintRequest) []byte {
+	b := escpos.New().WithQR(r.opts.QR).Init()
+
+	if req.Reprint {
+		r.reprintBanner(b)
+	}
+
+	switch req.Mode() {
+	case ModeReceiptOnly:
+		r.receipt(b, req)
+	case ModeQROnly:
+		r.labels(b, req.labels())
+	case ModeLabel:
+		r.labels(b, req.titledLabels())
+	case ModeSeparator:
+		r.separator(b)
+	default:
+		r.receipt(b, req)
+		if labels := req.labels(); len(labels) > 0 {
+			r.separator(b)
+			r.labels(b, labels)
+		}
+	}
+
+	// Bottom spacing before cut
+	b.Feed(2)
+	b.Cut()
+
+	return b.Bytes()
+}
+
+// receipt renders the customer copy
+func (r *Renderer) receipt(b *escpos.Builder, req PrintRequest) {
+	// Top spacing
+	b.Feed(1)
+
+	// Header - Branch name
+	r.header(b, req.Title)
+
+	// Body content - Left aligned (contains customer info and order details)
+	r.body(b, req.Body)
+
+	// Dashed separator before thank you message
+	b.Line(r.rule('-'))
+
+	// Thank you message - Centered
+	b.AlignCenter()
+	b.Bold(true)
+	b.Line("Terimakasih")
+	b.Bold(false)
+	b.Line("Atas Kepercayaan Anda")
+}
+
+// reprintBanner marks copies printed from the job history so they are not
+// mistaken for a new order
+func (r *Renderer) reprintBanner(b *escpos.Builder) {
+	b.Feed(1)
+	b.AlignCenter()
+	b.Bold(true)
+	b.DoubleHeight(true)
+	b.Line("*** REPRINT ***")
+	b.DoubleHeight(false)
+	b.Bold(false)
+	b.Line(r.rule('='))
+}
+
+// separator renders the barrier between the customer receipt and the
+// staff labels
+func (r *Renderer) separator(b *escpos.Builder) {
+	b.Feed(2)
+	b.AlignCenter()
+	b.Line(r.rule('-'))
+	b.Bold(true)
+	b.Line("--- Untuk Staff ---")
+	b.Bold(false)
+	b.Line(r.rule('-'))
+	b.Feed(1)
+}
+
+// labels renders one staff label per QR code
+func (r *Renderer) labels(b *escpos.Builder, labels []QRCodeData) {
+	for i, label := range labels {
+		if i > 0 {
+			// Spacing between QR codes
+			b.Feed(2)
+		}
+
+		// Top spacing
+		b.Feed(1)
+
+		// Header - Service name
+		r.header(b, label.ServiceName)
+
+		// Body content - Left aligned (contains customer info and order details)
+		r.body(b, label.Body)
+
+		// Dashed separator before QR code
+		b.Line(r.rule('-'))
+
+		// QR code - Centered
+		b.Feed(1)
+		b.AlignCenter()
+		b.QRCode(label.QRValue)
+
+		// Text below QR code
+		b.Feed(2)
+		b.Bold(true)
+		b.Line("Scan untuk update status")
+		b.Bold(false)
+	}
+}
+
+// header renders a centered, bold, double-height title
+func (r *Renderer) header(b *escpos.Builder, title string) {
+	b.AlignCenter()
+	b.Bold(true)
+	b.DoubleHeight(true)
+	b.Line(title)
+	b.DoubleHeight(false)
+	b.Bold(false)
+	b.Feed(1)
+}
+
+// body renders free-form text left aligned, making sure it ends with a
+// line feed so the following line starts on its own row
+func (r *Renderer) body(b *escpos.Builder, text string) {
+	b.AlignLeft()
+	b.Text(text)
+	if text != "" && !strings.HasSuffix(text, "\n") {
+		b.Feed(1)
+	}
+}
+
+// rule returns a full-width line of c
+func (r *Renderer) rule(c byte) string {
+	return strings.Repeat(string(c), r.opts.Width)
+}
@@ -0,0 +1,124 @@
+package render
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite golden files in testdata")
+
+const sampleBody = "Nama       : Bu Kayam\n" +
+	"Telp       : 0812 934 823\n" +
+	"--------------------------------\n" +
+	"Nomor      : VLN2 000 000 01\n" +
+	"Layanan    : Cuci Setrika\n" +
+	"Berat      : 13 kg\n" +
+	"Sub Total  : Rp. 104.000\n"
+
+var sampleQRCodes = []QRCodeData{
+	{
+		ServiceName: "Cuci + Setrika",
+		OrderID:     "ORD-12345-1",
+		Body:        "1x Kemeja Putih\n",
+		QRValue:     "https://cleanlink.com/track/ORD-12345-1",
+	},
+	{
+		ServiceName: "Dry Clean",
+		OrderID:     "ORD-12345-2",
+		Body:        "1x Jas Hitam",
+		QRValue:     "https://cleanlink.com/track/ORD-12345-2",
+	},
+}
+
+func TestRenderGolden(t *testing.T) {
+	tests := []struct {
+		name string
+		req  PrintRequest
+	}{
+		{"receipt-only", PrintRequest{Title: "Smart Laundry", OrderID: "ORD-12345", Body: sampleBody, PrintMode: ModeReceiptOnly}},
+		{"qr-only", PrintRequest{Title: "Smart Laundry", OrderID: "ORD-12345", Body: sampleBody, PrintMode: ModeQROnly, QRCodes: sampleQRCodes}},
+		{"all", PrintRequest{Title: "Smart Laundry", OrderID: "ORD-12345", Body: sampleBody, PrintMode: ModeAll, QRCodes: sampleQRCodes}},
+		{"label", PrintRequest{Title: "Smart Laundry", OrderID: "ORD-12345", Body: sampleBody, PrintMode: ModeLabel, QRCodes: sampleQRCodes}},
+		{"separator", PrintRequest{PrintMode: ModeSeparator}},
+		{"legacy-qr-value", PrintRequest{Title: "Smart Laundry", OrderID: "ORD-12345", Body: sampleBody, QRValue: "https://cleanlink.com/track/ORD-12345"}},
+		{"reprint", PrintRequest{Title: "Smart Laundry", OrderID: "ORD-12345", Body: sampleBody, PrintMode: ModeQROnly, QRCodes: sampleQRCodes[:1], Reprint: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Render(tt.req)
+			golden := filepath.Join("testdata", tt.name+".golden")
+
+			if *update {
+				if err := os.WriteFile(golden, got, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("%v (run go test ./render -update to create it)", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("output differs from %s\n got: %q\nwant: %q", golden, got, want)
+			}
+		})
+	}
+}
+
+func TestMode(t *testing.T) {
+	tests := []struct {
+		name string
+		req  PrintRequest
+		want string
+	}{
+		{"explicit", PrintRequest{PrintMode: ModeQROnly}, ModeQROnly},
+		{"qr codes", PrintRequest{QRCodes: sampleQRCodes}, ModeAll},
+		{"legacy qr value", PrintRequest{QRValue: "x"}, ModeAll},
+		{"no qr", PrintRequest{Body: "x"}, ModeReceiptOnly},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.req.Mode(); got != tt.want {
+				t.Errorf("Mode() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderWidth(t *testing.T) {
+	req := PrintRequest{PrintMode: ModeSeparator}
+	narrow := New(Options{Width: 32}).Render(req)
+	wide := New(Options{Width: 48}).Render(req)
+
+	if !bytes.Contains(wide, bytes.Repeat([]byte("-"), 48)) {
+		t.Error("48 column rule missing from wide output")
+	}
+	if bytes.Contains(narrow, bytes.Repeat([]byte("-"), 33)) {
+		t.Error("narrow output has a rule wider than 32 columns")
+	}
+}
+
+func TestParts(t *testing.T) {
+	all := PrintRequest{Title: "Smart Laundry", OrderID: "ORD-12345", Body: sampleBody, QRCodes: sampleQRCodes, Reprint: true}
+	parts := all.Parts()
+	if len(parts) != 2 || parts[0].Mode() != ModeReceiptOnly || parts[1].Mode() != ModeQROnly {
+		t.Fatalf("Parts() of all = %+v", parts)
+	}
+	if !parts[1].Reprint || len(parts[1].QRCodes) != 2 {
+		t.Errorf("labels part lost the request: %+v", parts[1])
+	}
+
+	receipt := Render(PrintRequest{Title: "Smart Laundry", OrderID: "ORD-12345", Body: sampleBody, PrintMode: ModeReceiptOnly})
+	if got := Render(PrintRequest{Title: "Smart Laundry", OrderID: "ORD-12345", Body: sampleBody, PrintMode: ModeAll}.Parts()[0]); !bytes.Equal(got, receipt) {
+		t.Error("all without labels does not render as the receipt")
+	}
+
+	if parts := (PrintRequest{PrintMode: ModeLabel, QRCodes: sampleQRCodes}).Parts(); len(parts) != 1 || parts[0].Mode() != ModeLabel {
+		t.Errorf("Parts() of label = %+v", parts)
+	}
+}
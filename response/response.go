@@ -0,0 +1,86 @@
+// Package response writes the agent's JSON responses. Errors carry a
+// stable code so the POS frontend can branch on it instead of parsing
+// messages, and everything is marshalled with encoding/json so Windows
+// error text containing quotes or backslashes stays valid JSON.
+package response
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// Code identifies the kind of error
+type Code string
+
+// Error codes
+const (
+	CodeInvalidJSON      Code = "INVALID_JSON"       // Request body is not valid JSON
+	CodeInvalidRequest   Code = "INVALID_REQUEST"    // Request is well-formed but not acceptable
+	CodeValidationFailed Code = "VALIDATION_FAILED"  // One or more fields are invalid, see "errors"
+	CodeRequestTooLarge  Code = "REQUEST_TOO_LARGE"  // Request body exceeds the size limit
+	CodeUnauthorized     Code = "UNAUTHORIZED"       // Missing or wrong token
+	CodeBadSignature     Code = "INVALID_SIGNATURE"  // Missing, wrong, expired or replayed request signature
+	CodeNotFound         Code = "NOT_FOUND"          // No such endpoint
+	CodeMethodNotAllowed Code = "METHOD_NOT_ALLOWED" // Wrong HTTP method
+	CodeOriginNotAllowed Code = "ORIGIN_NOT_ALLOWED" // Browser origin is not in allowed_origins
+	CodeIPNotAllowed     Code = "IP_NOT_ALLOWED"     // Client IP is not in allowed_ips (LAN mode)
+	CodeJobNotFound      Code = "JOB_NOT_FOUND"      // Unknown job ID
+	CodePrinterNotFound  Code = "PRINTER_NOT_FOUND"  // No printer selected or detected
+	CodePrinterOffline   Code = "PRINTER_OFFLINE"    // Printer found but cannot be opened
+	CodePaperOut         Code = "PAPER_OUT"          // Printer reports no paper
+	CodeCoverOpen        Code = "COVER_OPEN"         // Printer reports its cover open
+	CodePrinterError     Code = "PRINTER_ERROR"      // Printer reports a cutter or unrecoverable error
+	CodeWriteFailed      Code = "WRITE_FAILED"       // Printer stopped accepting data
+	CodeInternal         Code = "INTERNAL_ERROR"     // Anything else
+)
+
+// ErrorBody is the JSON body of every error response
+type ErrorBody struct {
+	Status  string `json:"status"` // Always "error"
+	Code    Code   `json:"code"`
+	Message string `json:"message"`
+}
+
+// JSON writes v as JSON with the given status code
+func JSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// Error writes an error response
+func Error(w http.ResponseWriter, status int, code Code, message string) {
+	JSON(w, status, ErrorBody{Status: "error", Code: code, Message: message})
+}
+
+// CodedError attaches a Code to an error without changing its message
+type CodedError struct {
+	Code Code
+	Err  error
+}
+
+// WithCode returns err tagged with code, or nil if err is nil
+func WithCode(code Code, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &CodedError{Code: code, Err: err}
+}
+
+func (e *CodedError) Error() string { return e.Err.Error() }
+
+func (e *CodedError) Unwrap() error { return e.Err }
+
+// ErrorCode returns the code as a string, so packages that only store the
+// code do not need to import this one
+func (e *CodedError) ErrorCode() string { return string(e.Code) }
+
+// CodeOf returns the code attached to err, or CodeInternal
+func CodeOf(err error) Code {
+	var coded *CodedError
+	if errors.As(err, &coded) {
+		return coded.Code
+	}
+	return CodeInternal
+}
@@ -0,0 +1,48 @@
+package response
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestErrorEscapesMessage(t *testing.T) {
+	rec := httptest.NewRecorder()
+	msg := `open \\.\COM10: The system cannot find the file "COM10"`
+	Error(rec, http.StatusInternalServerError, CodePrinterNotFound, msg)
+
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	var body ErrorBody
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("invalid JSON %q: %v", rec.Body, err)
+	}
+	if body.Status != "error" || body.Code != CodePrinterNotFound || body.Message != msg {
+		t.Errorf("unexpected body %+v", body)
+	}
+}
+
+func TestCodeOf(t *testing.T) {
+	base := errors.New("port closed")
+	coded := WithCode(CodeWriteFailed, base)
+
+	if coded.Error() != base.Error() {
+		t.Errorf("message changed to %q", coded)
+	}
+	if !errors.Is(coded, base) {
+		t.Error("WithCode does not unwrap")
+	}
+	if got := CodeOf(fmt.Errorf("job: %w", coded)); got != CodeWriteFailed {
+		t.Errorf("CodeOf(wrapped) = %s", got)
+	}
+	if got := CodeOf(base); got != CodeInternal {
+		t.Errorf("CodeOf(plain) = %s", got)
+	}
+	if WithCode(CodeWriteFailed, nil) != nil {
+		t.Error("WithCode(nil) is not nil")
+	}
+}
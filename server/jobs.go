@@ -0,0 +1,193 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"cleanlink/printer/queue"
+	"cleanlink/printer/render"
+	"cleanlink/printer/response"
+	"cleanlink/printer/validate"
+)
+
+// maxJobs limits GET /jobs without a filter to the most recent jobs
+const maxJobs = 50
+
+// jobStatus is what the API reports about a job. The receipt content stays
+// on the agent.
+type jobStatus struct {
+	ID        string     `json:"id"`
+	OrderID   string     `json:"order_id"`
+	PrintMode string     `json:"print_mode"`
+	State     string     `json:"state"`
+	Attempts  int        `json:"attempts"`
+	LastError string     `json:"last_error,omitempty"`
+	ErrorCode string     `json:"error_code,omitempty"`
+	Printer   string     `json:"printer,omitempty"`
+	ReprintOf string     `json:"reprint_of,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	NextTry   *time.Time `json:"next_try,omitempty"`
+	PrintedAt *time.Time `json:"printed_at,omitempty"`
+	// Parts reports each printer's piece of a job split by routes
+	Parts []queue.Part `json:"parts,omitempty"`
+}
+
+func newJobStatus(job queue.Job) jobStatus {
+	s := jobStatus{
+		ID:        job.ID,
+		OrderID:   job.Request.OrderID,
+		PrintMode: job.Request.Mode(),
+		State:     job.State,
+		Attempts:  job.Attempts,
+		LastError: job.LastError,
+		ErrorCode: job.ErrorCode,
+		Printer:   job.Printer,
+		ReprintOf: job.ReprintOf,
+		CreatedAt: job.CreatedAt,
+		UpdatedAt: job.UpdatedAt,
+		Parts:     job.Parts,
+	}
+	if job.State == queue.StateQueued && !job.NextTry.IsZero() {
+		s.NextTry = &job.NextTry
+	}
+	if !job.PrintedAt.IsZero() {
+		s.PrintedAt = &job.PrintedAt
+	}
+	return s
+}
+
+// ================= JOB HANDLERS =================
+
+// job serves GET /jobs/{id}
+func (s *Server) job(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r) {
+		response.Error(w, http.StatusUnauthorized, response.CodeUnauthorized, "Unauthorized")
+		return
+	}
+
+	job, err := s.queue.Get(r.PathValue("id"))
+	if errors.Is(err, queue.ErrNotFound) {
+		response.Error(w, http.StatusNotFound, response.CodeJobNotFound, "Job not found")
+		return
+	}
+
+	response.JSON(w, http.StatusOK, newJobStatus(job))
+}
+
+// jobs serves GET /jobs, optionally filtered by ?order_id=, newest first
+func (s *Server) jobs(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r) {
+		response.Error(w, http.StatusUnauthorized, response.CodeUnauthorized, "Unauthorized")
+		return
+	}
+
+	orderID := r.URL.Query().Get("order_id")
+	jobs := s.queue.Jobs(func(job queue.Job) bool {
+		return orderID == "" || job.Request.OrderID == orderID
+	})
+	slices.Reverse(jobs)
+	if orderID == "" && len(jobs) > maxJobs {
+		jobs = jobs[:maxJobs]
+	}
+
+	resp := struct {
+		Jobs []jobStatus `json:"jobs"`
+	}{Jobs: []jobStatus{}}
+	for _, job := range jobs {
+		resp.Jobs = append(resp.Jobs, newJobStatus(job))
+	}
+	response.JSON(w, http.StatusOK, resp)
+}
+
+// reprintRequest selects what to print again. Both fields are optional.
+type reprintRequest struct {
+	PrintMode string `json:"print_mode"`  // e.g. "qr-only" to reprint only the labels
+	QROrderID string `json:"qr_order_id"` // Reprint only the QR label with this order_id
+}
+
+// reprint serves POST /jobs/{id}/reprint. The copy is rendered from the
+// stored request with a REPRINT banner and linked to the original job.
+func (s *Server) reprint(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r) {
+		response.Error(w, http.StatusUnauthorized, response.CodeUnauthorized, "Unauthorized")
+		return
+	}
+
+	data, ok := readBody(w, r)
+	if !ok {
+		return
+	}
+	nonce, ok := s.verifySignature(w, r, s.opts.Load(), data)
+	if !ok {
+		return
+	}
+
+	var body reprintRequest
+	if len(bytes.TrimSpace(data)) > 0 {
+		if err := json.Unmarshal(data, &body); err != nil {
+			response.Error(w, http.StatusBadRequest, response.CodeInvalidJSON, "Invalid JSON")
+			return
+		}
+	}
+
+	original, err := s.queue.Get(r.PathValue("id"))
+	if errors.Is(err, queue.ErrNotFound) {
+		response.Error(w, http.StatusNotFound, response.CodeJobNotFound, "Job not found")
+		return
+	}
+
+	req := original.Request
+	req.ForceReprint = false
+	if body.PrintMode != "" {
+		if !render.ValidMode(body.PrintMode) {
+			response.Error(w, http.StatusBadRequest, response.CodeInvalidRequest, "Unknown print_mode")
+			return
+		}
+		req.PrintMode = body.PrintMode
+	}
+
+	if body.QROrderID != "" {
+		i := slices.IndexFunc(req.QRCodes, func(qr render.QRCodeData) bool {
+			return qr.OrderID == body.QROrderID
+		})
+		if i < 0 {
+			response.Error(w, http.StatusBadRequest, response.CodeInvalidRequest, "QR code not found in job")
+			return
+		}
+		req.QRCodes = req.QRCodes[i : i+1]
+		if body.PrintMode == "" {
+			req.PrintMode = render.ModeQROnly
+		}
+	}
+
+	if err := validate.PrintRequest(req, s.opts.Load().Renderer.Options()); err != nil {
+		validationFailed(w, err)
+		return
+	}
+	if !s.useNonce(w, r, nonce) {
+		return
+	}
+
+	job, err := s.queue.EnqueueReprint(original.ID, req)
+	if err != nil {
+		response.Error(w, http.StatusInternalServerError, response.CodeInternal, err.Error())
+		return
+	}
+
+	fmt.Printf("[DEBUG] Reprint of job %s for Order ID: %s queued as %s\n", original.ID, req.OrderID, job.ID)
+	response.JSON(w, http.StatusAccepted, printResponse{Status: job.State, JobID: job.ID})
+}
+
+// authorized checks the "Authorization: Bearer <key>" header. While
+// legacy_body_token is on, the legacy token is also accepted as ?token=,
+// so a POS that prints with the body token can follow its jobs.
+func (s *Server) authorized(r *http.Request) bool {
+	_, ok := authenticate(s.opts.Load(), r, r.URL.Query().Get("token"))
+	return ok
+}
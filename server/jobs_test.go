@@ -0,0 +1,190 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"cleanlink/printer/queue"
+	"cleanlink/printer/render"
+)
+
+func getJSON(t *testing.T, s *Server, path string, v any) int {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	req.Header.Set("Authorization", "Bearer "+testToken)
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, req)
+
+	if rec.Code == http.StatusOK {
+		if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return rec.Code
+}
+
+func TestGetJob(t *testing.T) {
+	s, mem := newTestServer(t)
+
+	id := enqueue(t, s, `{"token":"`+testToken+`","title":"Smart Laundry","order_id":"ORD-1","body":"Nama : Bu Kayam\n"}`)
+	waitJob(t, s, id, queue.StatePrinted)
+
+	var got map[string]any
+	if code := getJSON(t, s, "/jobs/"+id, &got); code != http.StatusOK {
+		t.Fatalf("status = %d", code)
+	}
+	if got["id"] != id || got["order_id"] != "ORD-1" || got["state"] != queue.StatePrinted ||
+		got["attempts"] != 1.0 || got["printer"] != mem.Status().Address ||
+		got["print_mode"] != render.ModeReceiptOnly || got["printed_at"] == nil {
+		t.Errorf("unexpected job %v", got)
+	}
+	if _, ok := got["body"]; ok {
+		t.Error("receipt body exposed")
+	}
+
+	if code := getJSON(t, s, "/jobs/nope", &got); code != http.StatusNotFound {
+		t.Errorf("unknown job: status = %d, want 404", code)
+	}
+}
+
+func TestListJobsByOrder(t *testing.T) {
+	s, _ := newTestServer(t)
+
+	first := enqueue(t, s, `{"token":"`+testToken+`","title":"Smart Laundry","order_id":"ORD-1"}`)
+	enqueue(t, s, `{"token":"`+testToken+`","title":"Smart Laundry","order_id":"ORD-2"}`)
+	second := enqueue(t, s, `{"token":"`+testToken+`","title":"Smart Laundry","order_id":"ORD-1"}`)
+
+	var resp struct {
+		Jobs []jobStatus `json:"jobs"`
+	}
+	if code := getJSON(t, s, "/jobs?order_id=ORD-1", &resp); code != http.StatusOK {
+		t.Fatalf("status = %d", code)
+	}
+	if len(resp.Jobs) != 2 || resp.Jobs[0].ID != second || resp.Jobs[1].ID != first {
+		t.Errorf("unexpected jobs %+v", resp.Jobs)
+	}
+
+	if code := getJSON(t, s, "/jobs?order_id=none", &resp); code != http.StatusOK || len(resp.Jobs) != 0 {
+		t.Errorf("status = %d, jobs = %+v", code, resp.Jobs)
+	}
+}
+
+func TestJobsRequireToken(t *testing.T) {
+	s, _ := newTestServer(t)
+
+	for _, path := range []string{"/jobs", "/jobs/x"} {
+		rec := do(t, s.Handler(), http.MethodGet, path, "")
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("%s: status = %d, want 401", path, rec.Code)
+		}
+	}
+}
+
+func TestJobsWithLegacyToken(t *testing.T) {
+	s, _ := newTestServer(t)
+	id := enqueue(t, s, `{"token":"`+testToken+`","title":"Smart Laundry","order_id":"ORD-1"}`)
+
+	for _, path := range []string{"/jobs?order_id=ORD-1&token=" + testToken, "/jobs/" + id + "?token=" + testToken} {
+		if rec := do(t, s.Handler(), http.MethodGet, path, ""); rec.Code != http.StatusOK {
+			t.Errorf("%s: status = %d, want 200", path, rec.Code)
+		}
+	}
+	if rec := do(t, s.Handler(), http.MethodGet, "/jobs/"+id+"?token=wrong", ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("wrong token: status = %d, want 401", rec.Code)
+	}
+
+	// Without legacy_body_token only API keys are accepted
+	opts := *s.opts.Load()
+	opts.BodyTokens = nil
+	s.SetOptions(opts)
+	if rec := do(t, s.Handler(), http.MethodGet, "/jobs/"+id+"?token="+testToken, ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("legacy token off: status = %d, want 401", rec.Code)
+	}
+}
+
+func postReprint(t *testing.T, s *Server, id, body string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodPost, "/jobs/"+id+"/reprint", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+testToken)
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, req)
+	return rec
+}
+
+func TestReprintSingleLabel(t *testing.T) {
+	s, mem := newTestServer(t)
+
+	original := render.PrintRequest{
+		Token:   testToken,
+		Title:   "Smart Laundry",
+		OrderID: "ORD-1",
+		Body:    "Nama : Bu Kayam\n",
+		QRCodes: []render.QRCodeData{
+			{ServiceName: "Cuci", OrderID: "ORD-1-1", QRValue: "https://cleanlink.com/track/ORD-1-1"},
+			{ServiceName: "Setrika", OrderID: "ORD-1-2", QRValue: "https://cleanlink.com/track/ORD-1-2"},
+		},
+	}
+	body, _ := json.Marshal(original)
+	id := enqueue(t, s, string(body))
+	waitJob(t, s, id, queue.StatePrinted)
+	mem.Reset()
+
+	rec := postReprint(t, s, id, `{"qr_order_id":"ORD-1-2"}`)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body)
+	}
+	var resp printResponse
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+
+	copyJob := waitJob(t, s, resp.JobID, queue.StatePrinted)
+	if copyJob.ReprintOf != id {
+		t.Errorf("reprint_of = %q, want %q", copyJob.ReprintOf, id)
+	}
+
+	want := original
+	want.PrintMode = render.ModeQROnly
+	want.QRCodes = original.QRCodes[1:]
+	want.Reprint = true
+	if got := mem.Bytes(); !bytes.Equal(got, render.Render(want)) {
+		t.Errorf("printer received %q", got)
+	}
+	if !bytes.Contains(mem.Bytes(), []byte("REPRINT")) {
+		t.Error("missing REPRINT banner")
+	}
+
+	// The copy shows up in the order history
+	var list struct {
+		Jobs []jobStatus `json:"jobs"`
+	}
+	getJSON(t, s, "/jobs?order_id=ORD-1", &list)
+	if len(list.Jobs) != 2 || list.Jobs[0].ReprintOf != id {
+		t.Errorf("unexpected history %+v", list.Jobs)
+	}
+}
+
+func TestReprintErrors(t *testing.T) {
+	s, _ := newTestServer(t)
+	id := enqueue(t, s, `{"token":"`+testToken+`","title":"Smart Laundry","order_id":"ORD-1"}`)
+
+	tests := []struct {
+		id, body string
+		code     int
+	}{
+		{"nope", "", http.StatusNotFound},
+		{id, `{"print_mode":"poster"}`, http.StatusBadRequest},
+		{id, `{"qr_order_id":"ORD-9"}`, http.StatusBadRequest},
+		{id, `{`, http.StatusBadRequest},
+		{id, "", http.StatusAccepted},
+	}
+	for _, tt := range tests {
+		if rec := postReprint(t, s, tt.id, tt.body); rec.Code != tt.code {
+			t.Errorf("reprint %s %q: status = %d, want %d", tt.id, tt.body, rec.Code, tt.code)
+		}
+	}
+}
@@ -0,0 +1,405 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"cleanlink/printer/escpos"
+	"cleanlink/printer/response"
+	"cleanlink/printer/status"
+	"cleanlink/printer/transport"
+)
+
+// Status monitor timings, shortened in tests
+var (
+	// asbTimeout is how long to wait for the first status block after
+	// enabling ASB before deciding the printer does not support it
+	asbTimeout = 3 * time.Second
+	// asbRetry is how long a printer that sent no status block is left to
+	// the check before each job before ASB is tried again, e.g. after its
+	// firmware setting was changed
+	asbRetry = 30 * time.Minute
+	// monitorRetry is the pause before connecting to the printer again. It
+	// doubles up to monitorMaxRetry while the printer cannot be monitored,
+	// since resolving an auto-detected printer is not free.
+	monitorRetry    = 5 * time.Second
+	monitorMaxRetry = time.Minute
+	// monitorPoll bounds each read, and so how long a job waits for the
+	// monitor to let go of the connection
+	monitorPoll = 200 * time.Millisecond
+	// eventKeepAlive is how often /events sends a comment so proxies keep
+	// the stream open
+	eventKeepAlive = 30 * time.Second
+)
+
+// monitor is the connection to one printer held by the status monitor
+type monitor struct {
+	mu      sync.Mutex
+	conn    transport.Transport // Open with ASB enabled, nil when not monitoring
+	address string              // Printer the tracker follows
+	tracker status.Tracker
+	noASB   map[string]time.Time // When printers last sent no status block
+}
+
+// held returns the monitor's connection if it is to the printer at address,
+// along with the printer's last status
+func (m *monitor) held(address string) (transport.Transport, escpos.Status) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.conn == nil || m.address != address {
+		return nil, escpos.Status{}
+	}
+	st, _ := m.tracker.Last()
+	return m.conn, st
+}
+
+func (m *monitor) attach(t transport.Transport, address string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.address != address {
+		m.tracker = status.Tracker{}
+	}
+	m.conn, m.address = t, address
+}
+
+func (m *monitor) detach() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.conn = nil
+}
+
+// skip reports whether the printer at address did not send a status block
+// within asbRetry
+func (m *monitor) skip(address string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	since, ok := m.noASB[address]
+	return ok && time.Since(since) < asbRetry
+}
+
+func (m *monitor) unsupported(address string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.noASB == nil {
+		m.noASB = map[string]time.Time{}
+	}
+	m.noASB[address] = time.Now()
+}
+
+// monitorOf returns the monitor of the named printer
+func (s *Server) monitorOf(name string) *monitor {
+	s.monitorsMu.Lock()
+	defer s.monitorsMu.Unlock()
+
+	m, ok := s.monitors[name]
+	if !ok {
+		if s.monitors == nil {
+			s.monitors = map[string]*monitor{}
+		}
+		m = &monitor{}
+		s.monitors[name] = m
+	}
+	return m
+}
+
+// held returns the connection a monitor holds to the printer at address,
+// along with the printer's last status
+func (s *Server) held(address string) (transport.Transport, escpos.Status) {
+	s.monitorsMu.Lock()
+	defer s.monitorsMu.Unlock()
+
+	for _, m := range s.monitors {
+		if conn, st := m.held(address); conn != nil {
+			return conn, st
+		}
+	}
+	return nil, escpos.Status{}
+}
+
+// ================= STATUS MONITOR =================
+
+// monitorPrinters runs monitorPrinter for the default printer and each
+// named printer, starting monitors for printers added by SetOptions. It
+// returns once ctx is cancelled and every monitor has stopped.
+func (s *Server) monitorPrinters(ctx context.Context) {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	running := map[string]chan struct{}{}
+	for ctx.Err() == nil {
+		names := []string{DefaultPrinter}
+		for name := range s.opts.Load().Printers {
+			names = append(names, name)
+		}
+
+		for _, name := range names {
+			if done, ok := running[name]; ok {
+				select {
+				case <-done: // Stopped after the printer was removed
+				default:
+					continue
+				}
+			}
+			done := make(chan struct{})
+			running[name] = done
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer close(done)
+				s.monitorPrinter(ctx, name)
+			}()
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(monitorRetry):
+		}
+	}
+}
+
+// monitorPrinter keeps a connection to the named printer with automatic
+// status back (ASB) enabled while Options.StatusMonitor is set, and
+// reconnects when it drops. It returns when the printer is removed.
+func (s *Server) monitorPrinter(ctx context.Context, name string) {
+	wait := monitorRetry
+	for ctx.Err() == nil {
+		opts := s.opts.Load()
+		_, err := printerNamed(opts, name)
+		switch {
+		case err != nil:
+			s.queue.Resume(name)
+			return
+		case !opts.StatusMonitor:
+			s.queue.Resume(name)
+			wait = monitorRetry
+		case s.watchPrinter(ctx, name):
+			wait = monitorRetry
+		default:
+			wait = min(wait*2, monitorMaxRetry)
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(wait):
+		}
+	}
+}
+
+// watchPrinter enables ASB on the named printer and reports its status
+// blocks until the connection fails, the options change or ctx is
+// cancelled. Printers that cannot read or never send a block are left to
+// the status check before each job, as are printers another monitor
+// already holds. It reports whether the printer was monitored.
+//
+// Jobs wait while ASB is probed: the port is open but not yet held, so a
+// job would otherwise open it a second time or close it under the monitor.
+func (s *Server) watchPrinter(ctx context.Context, name string) bool {
+	opts := s.opts.Load()
+	printer, err := printerNamed(opts, name)
+	if err != nil {
+		return false
+	}
+	t, err := printer()
+	if err != nil {
+		return false
+	}
+	m := s.monitorOf(name)
+	address := t.Status().Address
+	r, ok := t.(transport.Reader)
+	if !ok || m.skip(address) {
+		return false
+	}
+
+	s.mu.Lock()
+	if conn, _ := s.held(address); conn != nil {
+		s.mu.Unlock()
+		return false
+	}
+	err = t.Open()
+	if err == nil {
+		if _, err = t.Write(escpos.EnableASB(escpos.ASBDefault)); err != nil {
+			t.Close()
+		}
+	}
+	if err != nil {
+		s.mu.Unlock()
+		s.lost(name, address, err)
+		return false
+	}
+
+	var dec status.Decoder
+	buf := make([]byte, 64)
+	deadline := time.Now().Add(asbTimeout)
+	attached := false
+	for ctx.Err() == nil && s.opts.Load() == opts {
+		var n int
+		n, err = r.ReadTimeout(buf, monitorPoll)
+		if err != nil && !errors.Is(err, transport.ErrReadTimeout) {
+			break
+		}
+		err = nil
+
+		for _, st := range dec.Feed(buf[:n]) {
+			if !attached {
+				m.attach(t, address)
+				attached = true
+				s.mu.Unlock()
+				fmt.Printf("[STATUS] Monitoring %s\n", address)
+			}
+			s.report(name, address, st)
+		}
+		if !attached && time.Now().After(deadline) {
+			fmt.Printf("[STATUS] %s does not send automatic status, checking it before each job instead\n", address)
+			m.unsupported(address)
+			break
+		}
+	}
+
+	if attached {
+		s.mu.Lock()
+	}
+	m.detach()
+	if err == nil {
+		t.Write(escpos.EnableASB(0))
+	}
+	t.Close()
+	s.mu.Unlock()
+
+	if err != nil {
+		s.lost(name, address, err)
+	}
+	return attached
+}
+
+// report publishes the events caused by a status block and pauses the
+// named printer's jobs while it cannot print
+func (s *Server) report(name, address string, st escpos.Status) {
+	s.publishStatus(name, address, st)
+
+	if err := statusError(st); err != nil {
+		s.queue.Pause(name, err.Error())
+	} else {
+		s.queue.Resume(name)
+	}
+}
+
+// lost reports that the monitor could not reach the named printer. Its
+// jobs are resumed so they go back to retrying on their own.
+func (s *Server) lost(name, address string, err error) {
+	m := s.monitorOf(name)
+	m.mu.Lock()
+	_, known := m.tracker.Last()
+	tracked := known && m.address == address
+	m.mu.Unlock()
+
+	// Only printers the monitor has seen before are reported offline, so
+	// a printer that is switched off at startup does not raise an event
+	if tracked {
+		fmt.Printf("[STATUS] Lost connection to %s: %v\n", address, err)
+		s.publishStatus(name, address, escpos.Status{})
+	}
+	s.queue.Resume(name)
+}
+
+func (s *Server) publishStatus(name, address string, st escpos.Status) {
+	m := s.monitorOf(name)
+	m.mu.Lock()
+	types := m.tracker.Update(st)
+	m.mu.Unlock()
+
+	for _, typ := range types {
+		e := status.NewEvent(typ, address, st)
+		fmt.Printf("[STATUS] %s: %s\n", address, e.Message)
+		s.events.publish(e)
+	}
+}
+
+// ================= EVENTS =================
+
+// eventHub fans printer events out to subscribers
+type eventHub struct {
+	mu   sync.Mutex
+	subs map[chan status.Event]bool
+}
+
+func (h *eventHub) subscribe() (chan status.Event, func()) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.subs == nil {
+		h.subs = map[chan status.Event]bool{}
+	}
+	ch := make(chan status.Event, 16)
+	h.subs[ch] = true
+	return ch, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		delete(h.subs, ch)
+	}
+}
+
+func (h *eventHub) publish(e status.Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for ch := range h.subs {
+		select {
+		case ch <- e:
+		default: // Subscriber fell behind
+		}
+	}
+}
+
+// Subscribe returns printer status events as they happen, e.g. for a UI,
+// and a function that ends the subscription. Events are dropped for a
+// subscriber that falls behind.
+func (s *Server) Subscribe() (<-chan status.Event, func()) {
+	return s.events.subscribe()
+}
+
+// eventStream serves GET /events as server-sent events
+func (s *Server) eventStream(w http.ResponseWriter, r *http.Request) {
+	if !s.lanAuthorized(r) {
+		response.Error(w, http.StatusUnauthorized, response.CodeUnauthorized, "Unauthorized")
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		response.Error(w, http.StatusInternalServerError, response.CodeInternal, "Streaming not supported")
+		return
+	}
+
+	events, cancel := s.Subscribe()
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(eventKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case e := <-events:
+			data, _ := json.Marshal(e)
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, data)
+		}
+		flusher.Flush()
+	}
+}
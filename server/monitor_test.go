@@ -0,0 +1,271 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"cleanlink/printer/escpos"
+	"cleanlink/printer/queue"
+	"cleanlink/printer/render"
+	"cleanlink/printer/response"
+	"cleanlink/printer/status"
+	"cleanlink/printer/transport"
+)
+
+// ASB blocks
+var (
+	asbReady    = []byte{0x10, 0x00, 0x00, 0x00}
+	asbPaperOut = []byte{0x18, 0x00, 0x0F, 0x00}
+)
+
+// monitoredServer returns a test server with the status monitor on and a
+// printer that answers GS a with asb, or never if asb is nil
+func monitoredServer(t *testing.T, asb []byte) (*Server, *transport.Replier) {
+	t.Helper()
+
+	a, r, poll := asbTimeout, monitorRetry, monitorPoll
+	t.Cleanup(func() { asbTimeout, monitorRetry, monitorPoll = a, r, poll })
+	asbTimeout, monitorRetry, monitorPoll = 100*time.Millisecond, 10*time.Millisecond, 10*time.Millisecond
+
+	p := transport.NewReplier(func(b []byte) []byte {
+		if bytes.Equal(b, escpos.EnableASB(escpos.ASBDefault)) {
+			return asb
+		}
+		return nil
+	})
+
+	q, err := queue.Open(queue.Options{Backoff: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := New(Options{
+		BodyTokens:    []string{testToken},
+		Printer:       func() (transport.Transport, error) { return p, nil },
+		StatusMonitor: true,
+	}, q)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.Start(ctx)
+	t.Cleanup(func() {
+		cancel()
+		s.workers.Wait()
+	})
+	return s, p
+}
+
+// nextEvent waits for an event on events
+func nextEvent(t *testing.T, events <-chan status.Event) status.Event {
+	t.Helper()
+
+	select {
+	case e := <-events:
+		return e
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event")
+		return status.Event{}
+	}
+}
+
+func TestMonitorPausesQueue(t *testing.T) {
+	s, _ := monitoredServer(t, asbPaperOut)
+	events, cancel := s.Subscribe()
+	defer cancel()
+
+	if e := nextEvent(t, events); e.Type != status.EventPaperOut || e.Printer != "memory" || !e.Status.PaperOut {
+		t.Fatalf("unexpected event %+v", e)
+	}
+	if s.queue.Paused(DefaultPrinter) == "" {
+		t.Fatal("queue not paused while out of paper")
+	}
+
+	id := enqueue(t, s, `{"token":"`+testToken+`","title":"Smart Laundry"}`)
+	time.Sleep(50 * time.Millisecond)
+	if job, _ := s.queue.Get(id); job.State != queue.StateQueued || job.Attempts != 0 {
+		t.Errorf("job tried while paused: %+v", job)
+	}
+
+	rec := do(t, s.Handler(), http.MethodGet, "/check", "")
+	var resp checkResponse
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	if resp.Status != "error" || resp.QueuePaused == "" || resp.Printer == nil || !resp.Printer.PaperOut {
+		t.Errorf("unexpected /check response %+v", resp)
+	}
+
+	// Paper loaded
+	m := s.monitorOf(DefaultPrinter)
+	m.mu.Lock()
+	conn := m.conn.(*transport.Replier)
+	m.mu.Unlock()
+	conn.Push(asbReady)
+
+	if e := nextEvent(t, events); e.Type != status.EventRecovered {
+		t.Fatalf("unexpected event %+v", e)
+	}
+	waitJob(t, s, id, queue.StatePrinted)
+	if !bytes.Contains(conn.Bytes(), []byte("Smart Laundry")) {
+		t.Error("receipt not sent over the monitored connection")
+	}
+}
+
+// labelPrinter is a Replier at its own address, so it can be told apart
+// from the default printer
+type labelPrinter struct{ *transport.Replier }
+
+func (p labelPrinter) Status() transport.Status {
+	st := p.Replier.Status()
+	st.Address = "labels"
+	return st
+}
+
+func TestMonitorNamedPrinter(t *testing.T) {
+	s, counter := monitoredServer(t, asbReady)
+	labels := labelPrinter{transport.NewReplier(func(b []byte) []byte {
+		if bytes.Equal(b, escpos.EnableASB(escpos.ASBDefault)) {
+			return asbPaperOut
+		}
+		return nil
+	})}
+	events, cancel := s.Subscribe()
+	defer cancel()
+
+	opts := *s.opts.Load()
+	opts.Printers = map[string]func() (transport.Transport, error){
+		"labels": func() (transport.Transport, error) { return labels, nil },
+	}
+	opts.Routes = map[string]string{render.ModeQROnly: "labels"}
+	s.SetOptions(opts)
+
+	for {
+		e := nextEvent(t, events)
+		if e.Printer == "labels" {
+			if e.Type != status.EventPaperOut {
+				t.Fatalf("unexpected event %+v", e)
+			}
+			break
+		}
+	}
+	if s.queue.Paused("labels") == "" || s.queue.Paused(DefaultPrinter) != "" {
+		t.Fatalf("paused default %q, labels %q", s.queue.Paused(DefaultPrinter), s.queue.Paused("labels"))
+	}
+
+	// The receipt prints while the label printer is out of paper, and the
+	// labels wait for it without using up attempts
+	body, _ := json.Marshal(routedRequest)
+	id := enqueue(t, s, string(body))
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		job, _ := s.queue.Get(id)
+		if receipt, _ := job.Part(render.ModeReceiptOnly); receipt.State == queue.StatePrinted {
+			if qr, _ := job.Part(render.ModeQROnly); qr.State != queue.StateQueued || job.State != queue.StateQueued || job.Attempts != 0 {
+				t.Fatalf("job while the label printer is paused: %+v", job)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("receipt not printed while the label printer is paused: %+v", job)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if !bytes.Contains(counter.Bytes(), []byte("Smart Laundry")) {
+		t.Error("receipt not sent to the default printer")
+	}
+
+	// Paper loaded
+	m := s.monitorOf("labels")
+	m.mu.Lock()
+	m.conn.(labelPrinter).Push(asbReady)
+	m.mu.Unlock()
+
+	waitJob(t, s, id, queue.StatePrinted)
+	if !bytes.Contains(labels.Bytes(), []byte("ORD-12345-1")) {
+		t.Error("labels not sent to the label printer")
+	}
+}
+
+func TestMonitorWithoutASB(t *testing.T) {
+	s, p := monitoredServer(t, nil)
+
+	// A job submitted while ASB is probed waits for the probe to end
+	// instead of sharing the port with it
+	enable := escpos.EnableASB(escpos.ASBDefault)
+	deadline := time.Now().Add(5 * time.Second)
+	for !slices.ContainsFunc(p.Writes(), func(w []byte) bool { return bytes.Equal(w, enable) }) {
+		if time.Now().After(deadline) {
+			t.Fatal("ASB never enabled")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	id := enqueue(t, s, `{"token":"`+testToken+`","title":"Smart Laundry"}`)
+	waitJob(t, s, id, queue.StatePrinted)
+
+	m := s.monitorOf(DefaultPrinter)
+	if !m.skip("memory") {
+		t.Fatal("printer without ASB still monitored")
+	}
+	writes := p.Writes()
+	disable := slices.IndexFunc(writes, func(w []byte) bool { return bytes.Equal(w, escpos.EnableASB(0)) })
+	receipt := slices.IndexFunc(writes, func(w []byte) bool { return bytes.Contains(w, []byte("Smart Laundry")) })
+	if receipt < 0 || disable < 0 || receipt < disable {
+		t.Errorf("receipt written at %d, during the probe that ended at %d", receipt, disable)
+	}
+
+	// ASB is tried again once asbRetry has passed
+	m.mu.Lock()
+	m.noASB["memory"] = time.Now().Add(-asbRetry)
+	m.mu.Unlock()
+	if m.skip("memory") {
+		t.Error("printer without ASB skipped after asbRetry")
+	}
+}
+
+func TestEventStream(t *testing.T) {
+	s, _ := newTestServer(t)
+	srv := httptest.NewServer(s.Handler())
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+
+	// The handler subscribes before sending the headers
+	s.events.publish(status.NewEvent(status.EventCoverOpened, "COM10", escpos.Status{CoverOpen: true}))
+
+	r := bufio.NewReader(resp.Body)
+	line, _ := r.ReadString('\n')
+	if line != "event: cover_opened\n" {
+		t.Fatalf("got %q", line)
+	}
+	line, _ = r.ReadString('\n')
+	var e status.Event
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &e); err != nil {
+		t.Fatal(err)
+	}
+	if e.Printer != "COM10" || !e.Status.CoverOpen || e.Message == "" {
+		t.Errorf("unexpected event %+v", e)
+	}
+}
+
+func TestEventStreamWithoutFlusher(t *testing.T) {
+	s, _ := newTestServer(t)
+
+	// Hide the recorder's Flush, as a proxy or middleware might
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(struct{ http.ResponseWriter }{rec}, httptest.NewRequest(http.MethodGet, "/events", nil))
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("status = %d, want 500", rec.Code)
+	}
+	assertCode(t, rec, response.CodeInternal)
+}
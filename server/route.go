@@ -0,0 +1,144 @@
+package server
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"strings"
+
+	"cleanlink/printer/queue"
+	"cleanlink/printer/render"
+	"cleanlink/printer/response"
+	"cleanlink/printer/transport"
+)
+
+// DefaultPrinter names Options.Printer in routes
+const DefaultPrinter = "default"
+
+// NamedPrinters returns a Printer function for each configured printer, for
+// Options.Printers. A printer that cannot be set up reports why on every
+// job routed to it.
+func NamedPrinters(configs map[string]transport.Config) map[string]func() (transport.Transport, error) {
+	printers := make(map[string]func() (transport.Transport, error), len(configs))
+	for name, pc := range configs {
+		t, err := transport.New(pc)
+		printers[name] = func() (transport.Transport, error) { return t, err }
+	}
+	return printers
+}
+
+// routedPart is a piece of a job and the name of the printer it goes to
+type routedPart struct {
+	printer string
+	req     render.PrintRequest
+}
+
+// route splits req into the pieces that print on different printers. A job
+// whose pieces all go to the same printer stays whole, so it prints exactly
+// as it would without routes.
+func route(opts *Options, req render.PrintRequest) []routedPart {
+	var parts []routedPart
+	for _, p := range req.Parts() {
+		parts = append(parts, routedPart{printer: cmp.Or(opts.Routes[p.Mode()], DefaultPrinter), req: p})
+	}
+	for _, p := range parts[1:] {
+		if p.printer != parts[0].printer {
+			return parts
+		}
+	}
+	return []routedPart{{printer: parts[0].printer, req: req}}
+}
+
+// printerNamed returns the Printer function of the named printer
+func printerNamed(opts *Options, name string) (func() (transport.Transport, error), error) {
+	if name == DefaultPrinter {
+		return opts.Printer, nil
+	}
+	printer, ok := opts.Printers[name]
+	if !ok {
+		return nil, response.WithCode(response.CodePrinterNotFound, fmt.Errorf("No printer named %q", name))
+	}
+	return printer, nil
+}
+
+// jobPrinters returns the names of the printers job has left to print on,
+// so the queue holds it back while they are all paused
+func (s *Server) jobPrinters(job queue.Job) []string {
+	var printers []string
+	for _, p := range route(s.opts.Load(), job.Request) {
+		if done, _ := job.Part(p.req.Mode()); done.State != queue.StatePrinted {
+			printers = append(printers, p.printer)
+		}
+	}
+	return printers
+}
+
+// paused returns an error wrapping queue.ErrPaused if the status monitor
+// paused the named printer
+func (s *Server) paused(printer string) error {
+	if reason := s.queue.Paused(printer); reason != "" {
+		return fmt.Errorf("%w: %s", queue.ErrPaused, reason)
+	}
+	return nil
+}
+
+// printPart renders p and sends it to its printer, returning the printer's
+// address
+func (s *Server) printPart(opts *Options, job queue.Job, p routedPart) (string, error) {
+	printer, err := printerNamed(opts, p.printer)
+	if err != nil {
+		return "", err
+	}
+	t, err := printer()
+	if err != nil {
+		return "", response.WithCode(response.CodePrinterNotFound, err)
+	}
+
+	address := t.Status().Address
+	if p.printer == DefaultPrinter {
+		fmt.Printf("[DEBUG] Using printer %s for Order ID: %s\n", address, job.Request.OrderID)
+	} else {
+		fmt.Printf("[DEBUG] Using printer %s (%s) for %s of Order ID: %s\n", p.printer, address, p.req.Mode(), job.Request.OrderID)
+	}
+
+	return address, s.send(t, opts.Renderer.Render(p.req))
+}
+
+// printParts sends each piece of a split job to its own printer and records
+// how each went on the job. Pieces printed by an earlier attempt are
+// skipped, so a sleeping label printer does not reprint the receipt.
+// Pieces for paused printers wait without costing the job an attempt,
+// unless another piece failed.
+func (s *Server) printParts(opts *Options, job queue.Job, parts []routedPart) (string, error) {
+	var addresses []string
+	var errs, waiting []error
+	for _, p := range parts {
+		mode := p.req.Mode()
+		if done, _ := job.Part(mode); done.State == queue.StatePrinted {
+			addresses = append(addresses, done.Address)
+			continue
+		}
+
+		address, err := "", s.paused(p.printer)
+		if err == nil {
+			address, err = s.printPart(opts, job, p)
+		}
+		if address != "" {
+			addresses = append(addresses, address)
+		}
+		switch {
+		case errors.Is(err, queue.ErrPaused):
+			waiting = append(waiting, fmt.Errorf("%s: %w", mode, err))
+		case err != nil:
+			errs = append(errs, fmt.Errorf("%s: %w", mode, err))
+		}
+		part := queue.Part{Mode: mode, Printer: p.printer, Address: address}
+		if err := s.queue.SetPart(job.ID, part, err); err != nil {
+			fmt.Println("[QUEUE] Cannot save job:", err)
+		}
+	}
+	if len(errs) == 0 {
+		errs = waiting
+	}
+	return strings.Join(addresses, ", "), errors.Join(errs...)
+}
@@ -0,0 +1,133 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"cleanlink/printer/queue"
+	"cleanlink/printer/render"
+	"cleanlink/printer/transport"
+)
+
+// routedRequest is an "all" job with a receipt and two QR labels
+var routedRequest = render.PrintRequest{
+	Token:     testToken,
+	Title:     "Smart Laundry",
+	OrderID:   "ORD-12345",
+	Body:      "Nama : Bu Kayam\n",
+	PrintMode: render.ModeAll,
+	QRCodes: []render.QRCodeData{
+		{ServiceName: "Cuci + Setrika", OrderID: "ORD-12345-1", QRValue: "https://cleanlink.com/track/ORD-12345-1"},
+		{ServiceName: "Dry Clean", OrderID: "ORD-12345-2", QRValue: "https://cleanlink.com/track/ORD-12345-2"},
+	},
+}
+
+// routedServer returns a test server whose "labels" printer is returned by
+// labels, with routes
+func routedServer(t *testing.T, routes map[string]string, labels func() (transport.Transport, error)) (*Server, *transport.Memory) {
+	t.Helper()
+
+	s, counter := newTestServer(t)
+	opts := *s.opts.Load()
+	opts.Printers = map[string]func() (transport.Transport, error){"labels": labels}
+	opts.Routes = routes
+	s.SetOptions(opts)
+	return s, counter
+}
+
+func TestRouteSplitsAllJob(t *testing.T) {
+	labels := transport.NewMemory()
+	s, counter := routedServer(t, map[string]string{render.ModeQROnly: "labels"},
+		func() (transport.Transport, error) { return labels, nil })
+
+	body, _ := json.Marshal(routedRequest)
+	id := enqueue(t, s, string(body))
+	job := waitJob(t, s, id, queue.StatePrinted)
+
+	parts := routedRequest.Parts()
+	if got, want := counter.Bytes(), render.Render(parts[0]); !bytes.Equal(got, want) {
+		t.Errorf("counter printer received %q, want the receipt %q", got, want)
+	}
+	if got, want := labels.Bytes(), render.Render(parts[1]); !bytes.Equal(got, want) {
+		t.Errorf("label printer received %q, want the labels %q", got, want)
+	}
+
+	receipt, _ := job.Part(render.ModeReceiptOnly)
+	qr, _ := job.Part(render.ModeQROnly)
+	if receipt.State != queue.StatePrinted || receipt.Printer != DefaultPrinter || qr.State != queue.StatePrinted || qr.Printer != "labels" {
+		t.Errorf("unexpected parts %+v", job.Parts)
+	}
+
+	var status jobStatus
+	if code := getJSON(t, s, "/jobs/"+id, &status); code != http.StatusOK {
+		t.Fatalf("status = %d", code)
+	}
+	if len(status.Parts) != 2 || status.Parts[1].Mode != render.ModeQROnly || status.Parts[1].Printer != "labels" {
+		t.Errorf("GET /jobs/%s parts = %+v", id, status.Parts)
+	}
+}
+
+func TestRouteRetriesOnlyFailedPart(t *testing.T) {
+	labels := transport.NewMemory()
+	var online atomic.Bool
+	s, counter := routedServer(t, map[string]string{render.ModeReceiptOnly: DefaultPrinter, render.ModeQROnly: "labels"},
+		func() (transport.Transport, error) {
+			if !online.Load() {
+				return nil, errors.New("label printer offline")
+			}
+			return labels, nil
+		})
+
+	body, _ := json.Marshal(routedRequest)
+	id := enqueue(t, s, string(body))
+
+	// Wait for the label printer to fail, then bring it back
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		job, _ := s.queue.Get(id)
+		if job.LastError != "" {
+			if qr, _ := job.Part(render.ModeQROnly); qr.State != queue.StateQueued || job.LastError != "qr-only: label printer offline" {
+				t.Errorf("unexpected job %+v", job)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("label part never failed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	online.Store(true)
+
+	job := waitJob(t, s, id, queue.StatePrinted)
+	if n := len(counter.Writes()); n != 1 {
+		t.Errorf("receipt printed %d times, want 1", n)
+	}
+	if len(labels.Bytes()) == 0 {
+		t.Error("labels not printed")
+	}
+	if qr, _ := job.Part(render.ModeQROnly); qr.State != queue.StatePrinted || qr.LastError != "" {
+		t.Errorf("unexpected labels part %+v", qr)
+	}
+}
+
+func TestRouteSamePrinterKeepsJobWhole(t *testing.T) {
+	labels := transport.NewMemory()
+	s, counter := routedServer(t, map[string]string{render.ModeReceiptOnly: "labels", render.ModeQROnly: "labels"},
+		func() (transport.Transport, error) { return labels, nil })
+
+	body, _ := json.Marshal(routedRequest)
+	id := enqueue(t, s, string(body))
+	job := waitJob(t, s, id, queue.StatePrinted)
+
+	if got, want := labels.Bytes(), render.Render(routedRequest); !bytes.Equal(got, want) {
+		t.Errorf("printer received %q, want the whole job %q", got, want)
+	}
+	if len(counter.Bytes()) != 0 || len(job.Parts) != 0 {
+		t.Errorf("job was split: %+v", job.Parts)
+	}
+}
@@ -0,0 +1,813 @@
+// Package server implements the HTTP API shared by every agent build.
+//
+// Agents only decide where jobs go by supplying a Printer function that
+// returns a transport; the handlers never know how bytes reach the printer.
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"cleanlink/printer/auth"
+	"cleanlink/printer/config"
+	"cleanlink/printer/cors"
+	"cleanlink/printer/escpos"
+	"cleanlink/printer/queue"
+	"cleanlink/printer/render"
+	"cleanlink/printer/response"
+	"cleanlink/printer/status"
+	"cleanlink/printer/transport"
+	"cleanlink/printer/validate"
+)
+
+// ErrNoPrinter is returned by Printer functions when no printer has been
+// selected yet
+var ErrNoPrinter = errors.New("No printer selected")
+
+// Options configures a Server
+type Options struct {
+	// Keys are the API keys accepted as "Authorization: Bearer <key>"
+	Keys []auth.Key
+	// BodyTokens are the legacy tokens accepted in the print request body;
+	// nil accepts none
+	BodyTokens []string
+	// SigningSecret, when set, requires print and reprint requests to be
+	// signed with it; see auth.Sign
+	SigningSecret string
+	// SigningWindow is how far a signed request's timestamp may be from
+	// the local clock
+	SigningWindow time.Duration
+	// AllowedIPs are the client ranges served in LAN mode besides
+	// loopback; nil serves every client
+	AllowedIPs []netip.Prefix
+	// Origins are the web origins browsers may call the agent from; nil
+	// allows none
+	Origins *cors.Allowlist
+	// DefaultPrintMode is used for requests without a print_mode; empty
+	// keeps the automatic choice
+	DefaultPrintMode string
+	// Printer returns the transport the next job should be sent to
+	Printer func() (transport.Transport, error)
+	// Printers are further printers by name that Routes can send jobs to,
+	// e.g. a label printer in the back room
+	Printers map[string]func() (transport.Transport, error)
+	// Routes maps a print mode to the name of the printer that prints it,
+	// e.g. "qr-only" to "labels". An "all" job is split into its receipt
+	// and QR labels when they are routed to different printers. Modes
+	// without a route, and DefaultPrinter, print on Printer.
+	Routes map[string]string
+	// Renderer lays out receipts; nil uses render.DefaultOptions
+	Renderer *render.Renderer
+	// IdempotencyWindow is how long a resubmitted job returns the original
+	// job instead of printing again; 0 disables deduplication
+	IdempotencyWindow time.Duration
+	// StatusMonitor keeps the printer connection open with automatic
+	// status back enabled, so paper and cover problems are reported on
+	// /events as they happen and pause the queue
+	StatusMonitor bool
+}
+
+// OptionsFrom returns the Options for cfg, sending jobs without a route to
+// printer. Every agent builds its server from the same settings this way.
+func OptionsFrom(cfg *config.Config, printer func() (transport.Transport, error)) Options {
+	return Options{
+		Keys:             cfg.APIKeys,
+		BodyTokens:       cfg.BodyTokens(),
+		SigningSecret:    cfg.SigningSecret,
+		SigningWindow:    time.Duration(cfg.SigningWindow),
+		Origins:          cfg.Origins(),
+		AllowedIPs:       cfg.IPAllowlist(),
+		DefaultPrintMode: cfg.DefaultPrintMode,
+		Printer:          printer,
+		Printers:         NamedPrinters(cfg.Printers),
+		Routes:           cfg.Routes,
+		Renderer:         render.New(cfg.RenderOptions()),
+
+		IdempotencyWindow: time.Duration(cfg.IdempotencyWindow),
+		StatusMonitor:     cfg.StatusMonitor,
+	}
+}
+
+// Server serves /ping, /print, /check, /cert, /events and /jobs
+type Server struct {
+	opts  atomic.Pointer[Options]
+	mux   *http.ServeMux
+	queue *queue.Queue
+
+	// verifier remembers used signatures across option changes
+	verifier *auth.Verifier
+	// caCert is the local CA served by GET /cert, if HTTPS is on
+	caCert atomic.Pointer[[]byte]
+
+	// mu serializes jobs so two receipts never interleave on one printer
+	mu sync.Mutex
+	// workers tracks Start so Shutdown can wait for the job being printed
+	workers sync.WaitGroup
+	// noStatus holds when printers, by address, last did not answer a
+	// status query, so the jobs that follow do not wait for the timeout
+	// again until statusRetry has passed
+	noStatus sync.Map
+	// monitors hold the printer connections while ASB is enabled, by
+	// printer name
+	monitorsMu sync.Mutex
+	monitors   map[string]*monitor
+	// events fans printer events out to /events and Subscribe
+	events eventHub
+}
+
+// New returns a server that queues jobs in q; a nil q keeps jobs in memory
+func New(opts Options, q *queue.Queue) *Server {
+	if q == nil {
+		q, _ = queue.Open(queue.Options{})
+	}
+
+	s := &Server{mux: http.NewServeMux(), queue: q, verifier: auth.NewVerifier()}
+	s.SetOptions(opts)
+	s.mux.HandleFunc("/ping", s.ping)
+	s.mux.HandleFunc("/print", s.print)
+	s.mux.HandleFunc("/check", s.check)
+	s.mux.HandleFunc("GET /cert", s.cert)
+	s.mux.HandleFunc("GET /events", s.eventStream)
+	s.mux.HandleFunc("GET /jobs", s.jobs)
+	s.mux.HandleFunc("GET /jobs/{id}", s.job)
+	s.mux.HandleFunc("POST /jobs/{id}/reprint", s.reprint)
+
+	// Anything else gets a JSON error instead of the plain text ServeMux
+	// writes
+	s.mux.HandleFunc("/cert", methodNotAllowed(http.MethodGet))
+	s.mux.HandleFunc("/events", methodNotAllowed(http.MethodGet))
+	s.mux.HandleFunc("/jobs", methodNotAllowed(http.MethodGet))
+	s.mux.HandleFunc("/jobs/{id}", methodNotAllowed(http.MethodGet))
+	s.mux.HandleFunc("/jobs/{id}/reprint", methodNotAllowed(http.MethodPost))
+	s.mux.HandleFunc("/", notFound)
+	return s
+}
+
+// methodNotAllowed answers a route that exists with the wrong method
+func methodNotAllowed(allow string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Allow", allow)
+		response.Error(w, http.StatusMethodNotAllowed, response.CodeMethodNotAllowed, "Method not allowed")
+	}
+}
+
+func notFound(w http.ResponseWriter, r *http.Request) {
+	response.Error(w, http.StatusNotFound, response.CodeNotFound, "Not found")
+}
+
+// SetOptions replaces the options atomically. Jobs already being handled
+// finish with the options they started with.
+func (s *Server) SetOptions(opts Options) {
+	if opts.Renderer == nil {
+		opts.Renderer = render.New(render.DefaultOptions)
+	}
+	s.opts.Store(&opts)
+}
+
+// SetCACert makes GET /cert serve the local CA certificate (PEM), so users
+// can install it and trust the agent's HTTPS certificate
+func (s *Server) SetCACert(pem []byte) {
+	s.caCert.Store(&pem)
+}
+
+// Listener is an address the API is served on
+type Listener struct {
+	Addr string
+	// TLS serves HTTPS with this config; nil serves plain HTTP
+	TLS *tls.Config
+}
+
+// ListenAndServe serves the API on every listener until ctx is cancelled,
+// then stops accepting requests and waits briefly for open ones. It
+// returns the first listener error, e.g. when a port is already in use,
+// or nil after ctx is cancelled.
+func (s *Server) ListenAndServe(ctx context.Context, listeners ...Listener) error {
+	servers := make([]*http.Server, len(listeners))
+	errc := make(chan error, len(listeners))
+	for i, l := range listeners {
+		srv := &http.Server{
+			Addr:      l.Addr,
+			Handler:   s.Handler(),
+			TLSConfig: l.TLS,
+			// Cancelling ctx also ends open /events streams
+			BaseContext: func(net.Listener) context.Context { return ctx },
+		}
+		servers[i] = srv
+		go func() {
+			if srv.TLSConfig != nil {
+				errc <- srv.ListenAndServeTLS("", "")
+			} else {
+				errc <- srv.ListenAndServe()
+			}
+		}()
+	}
+
+	var err error
+	select {
+	case err = <-errc:
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), httpShutdownTimeout)
+	defer cancel()
+	for _, srv := range servers {
+		srv.Shutdown(shutdownCtx)
+	}
+	if err == nil {
+		fmt.Println("[SHUTDOWN] HTTP server stopped")
+	}
+	return err
+}
+
+// Start prints queued jobs and monitors the printers in the background
+// until ctx is cancelled. The job being printed when ctx is cancelled is
+// finished first. The workers are registered before Start returns, so a
+// Shutdown right after it still waits for them.
+func (s *Server) Start(ctx context.Context) {
+	s.workers.Add(2)
+	go func() {
+		defer s.workers.Done()
+		s.monitorPrinters(ctx)
+	}()
+	go func() {
+		defer s.workers.Done()
+		s.queue.Run(ctx, s.printJob, s.jobPrinters)
+	}()
+}
+
+// Timeouts used while shutting down, shortened in tests
+var (
+	httpShutdownTimeout = 5 * time.Second
+	resetTimeout        = 3 * time.Second
+)
+
+// Status query timings, shortened in tests
+var (
+	// statusTimeout is how long to wait for each status reply
+	statusTimeout = status.DefaultTimeout
+	// statusRetry is how long a printer that did not answer is printed to
+	// without status checks before it is asked again, e.g. a Bluetooth
+	// printer that was asleep at the first query
+	statusRetry = 10 * time.Minute
+)
+
+// Shutdown waits for the workers to stop after their context was
+// cancelled, then sends ESC @ so a job cut off mid-receipt cannot leave
+// bold or double height switched on. If ctx expires before the printer
+// finishes, the job is requeued and prints again on the next start.
+func (s *Server) Shutdown(ctx context.Context) {
+	done := make(chan struct{})
+	go func() {
+		s.workers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		fmt.Println("[SHUTDOWN] Print worker stopped")
+	case <-ctx.Done():
+		for _, id := range s.queue.Requeue() {
+			fmt.Printf("[SHUTDOWN] Job %s did not finish in time, requeued\n", id)
+		}
+	}
+
+	s.resetPrinter()
+
+	queued := s.queue.Jobs(func(job queue.Job) bool { return job.State == queue.StateQueued })
+	if len(queued) > 0 {
+		fmt.Printf("[SHUTDOWN] %d job(s) still queued, they print after the next start\n", len(queued))
+	}
+	fmt.Println("[SHUTDOWN] Done")
+}
+
+// resetPrinter sends ESC @ to the current printer unless a job still holds
+// it
+func (s *Server) resetPrinter() {
+	result := make(chan error, 1)
+	go func() {
+		t, err := s.opts.Load().Printer()
+		if err != nil {
+			result <- err
+			return
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		result <- transport.Send(t, escpos.New().Init().Bytes())
+	}()
+
+	select {
+	case err := <-result:
+		if err != nil {
+			fmt.Println("[SHUTDOWN] Printer not reset:", err)
+			return
+		}
+		fmt.Println("[SHUTDOWN] Printer reset")
+	case <-time.After(resetTimeout):
+		fmt.Println("[SHUTDOWN] Printer busy, not reset")
+	}
+}
+
+// Handler returns the HTTP handler with the IP filter and CORS applied
+func (s *Server) Handler() http.Handler {
+	return s.ipFilter(s.corsMiddleware(s.mux))
+}
+
+// ================= IP FILTER MIDDLEWARE =================
+
+// ipFilter refuses clients outside AllowedIPs. Loopback is always served.
+// Without AllowedIPs only connections that arrived on a loopback address
+// are served, so a listener still bound to the network after lan was
+// turned off does not serve every client.
+func (s *Server) ipFilter(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		allowed := s.opts.Load().AllowedIPs
+		if allowed == nil && !arrivedOnNetwork(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		addr, err := netip.ParseAddrPort(r.RemoteAddr)
+		ip := addr.Addr().Unmap()
+		if err != nil || !(ip.IsLoopback() || slices.ContainsFunc(allowed, func(p netip.Prefix) bool { return p.Contains(ip) })) {
+			fmt.Printf("[LAN] Rejected %s %s from %s\n", r.Method, r.URL.Path, r.RemoteAddr)
+			response.Error(w, http.StatusForbidden, response.CodeIPNotAllowed, "Client address not allowed")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// arrivedOnNetwork reports whether r reached the agent on an address other
+// devices can connect to
+func arrivedOnNetwork(r *http.Request) bool {
+	local, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	if !ok {
+		return false
+	}
+	addr, err := netip.ParseAddrPort(local.String())
+	return err == nil && !addr.Addr().Unmap().IsLoopback()
+}
+
+// lanAuthorized reports whether r may use /check and /events. In LAN mode
+// clients other than this PC need an API key for them, as they do to
+// print; the POS page on this PC keeps using them without one.
+func (s *Server) lanAuthorized(r *http.Request) bool {
+	if s.opts.Load().AllowedIPs == nil && !arrivedOnNetwork(r) {
+		return true
+	}
+	addr, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err == nil && addr.Addr().Unmap().IsLoopback() {
+		return true
+	}
+	return s.authorized(r)
+}
+
+// ================= CORS MIDDLEWARE =================
+
+// corsMiddleware lets browsers on allowed origins call the agent. Requests
+// from other origins are refused before they reach a handler; requests
+// without an Origin header (curl, backends) are not affected.
+func (s *Server) corsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Origin")
+		if !s.opts.Load().Origins.Allowed(origin) {
+			fmt.Printf("[CORS] Rejected %s %s from origin %s\n", r.Method, r.URL.Path, origin)
+			response.Error(w, http.StatusForbidden, response.CodeOriginNotAllowed, "Origin not allowed")
+			return
+		}
+
+		// Set CORS headers
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Idempotency-Key, "+auth.TimestampHeader+", "+auth.SignatureHeader)
+		w.Header().Set("Access-Control-Max-Age", "3600")
+
+		// Handle preflight OPTIONS request
+		if r.Method == http.MethodOptions {
+			// Chrome's Private Network Access asks before a public https
+			// page may talk to localhost
+			if r.Header.Get("Access-Control-Request-Private-Network") == "true" {
+				w.Header().Set("Access-Control-Allow-Private-Network", "true")
+			}
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		// Call the next handler
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ================= HANDLERS =================
+
+func (s *Server) ping(w http.ResponseWriter, r *http.Request) {
+	response.JSON(w, http.StatusOK, map[string]string{
+		"status": "ok",
+		"agent":  "cleanlink-printer",
+	})
+}
+
+// cert serves the local CA certificate for installation
+func (s *Server) cert(w http.ResponseWriter, r *http.Request) {
+	pem := s.caCert.Load()
+	if pem == nil {
+		response.Error(w, http.StatusNotFound, response.CodeNotFound, "HTTPS is not enabled")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-x509-ca-cert")
+	w.Header().Set("Content-Disposition", `attachment; filename="cleanlink-local-ca.crt"`)
+	w.Write(*pem)
+}
+
+// checkResponse is the body of /check. Code is set when Status is "error".
+type checkResponse struct {
+	Status  string        `json:"status"`
+	Code    response.Code `json:"code,omitempty"`
+	Message string        `json:"message"`
+	COM     string        `json:"com"`
+	// Printer is the real-time status, if the printer can report it
+	Printer *escpos.Status `json:"printer_status,omitempty"`
+	// QueuePaused is why the queue is waiting for the printer, if it is
+	QueuePaused string `json:"queue_paused,omitempty"`
+}
+
+func (s *Server) check(w http.ResponseWriter, r *http.Request) {
+	if !s.lanAuthorized(r) {
+		response.Error(w, http.StatusUnauthorized, response.CodeUnauthorized, "Unauthorized")
+		return
+	}
+
+	t, err := s.opts.Load().Printer()
+	if err != nil {
+		response.JSON(w, http.StatusOK, checkResponse{
+			Status:  "error",
+			Code:    response.CodePrinterNotFound,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	// Open the printer to verify it's accessible, then ask how it is,
+	// unless the status monitor already knows
+	var st *escpos.Status
+	s.mu.Lock()
+	if conn, last := s.held(t.Status().Address); conn != nil {
+		st = &last
+	} else if err = t.Open(); err != nil {
+		err = &transport.OpError{Op: "open", Err: err}
+	} else {
+		if st, err = s.queryStatus(t); err != nil {
+			err = &transport.OpError{Op: "check", Err: err}
+		}
+		t.Close()
+	}
+	s.mu.Unlock()
+
+	if err != nil {
+		err = printerError(err)
+		response.JSON(w, http.StatusOK, checkResponse{
+			Status:  "error",
+			Code:    response.CodeOf(err),
+			Message: "Cannot access printer: " + err.Error(),
+			COM:     t.Status().Address,
+		})
+		return
+	}
+	if st != nil {
+		if err := statusError(*st); err != nil {
+			response.JSON(w, http.StatusOK, checkResponse{
+				Status:      "error",
+				Code:        response.CodeOf(err),
+				Message:     "Printer not ready: " + err.Error(),
+				COM:         t.Status().Address,
+				Printer:     st,
+				QueuePaused: s.queue.Paused(DefaultPrinter),
+			})
+			return
+		}
+	}
+
+	message := "Printer detected and accessible"
+	if st != nil && st.PaperNearEnd {
+		message += ", paper is running low"
+	}
+	response.JSON(w, http.StatusOK, checkResponse{
+		Status:      "ok",
+		Message:     message,
+		COM:         t.Status().Address,
+		Printer:     st,
+		QueuePaused: s.queue.Paused(DefaultPrinter),
+	})
+}
+
+func (s *Server) print(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.Error(w, http.StatusMethodNotAllowed, response.CodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	opts := s.opts.Load()
+
+	body, ok := readBody(w, r)
+	if !ok {
+		return
+	}
+	nonce, ok := s.verifySignature(w, r, opts, body)
+	if !ok {
+		return
+	}
+
+	var req render.PrintRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		response.Error(w, http.StatusBadRequest, response.CodeInvalidJSON, "Invalid JSON")
+		return
+	}
+
+	client, ok := authenticate(opts, r, req.Token)
+	if !ok {
+		response.Error(w, http.StatusUnauthorized, response.CodeUnauthorized, "Unauthorized")
+		return
+	}
+
+	// Only copies queued by /jobs/{id}/reprint carry the banner
+	req.Reprint = false
+	if req.PrintMode == "" {
+		req.PrintMode = opts.DefaultPrintMode
+	}
+
+	if err := validate.PrintRequest(req, opts.Renderer.Options()); err != nil {
+		validationFailed(w, err)
+		return
+	}
+	if !s.useNonce(w, r, nonce) {
+		return
+	}
+
+	var key string
+	var window time.Duration
+	if !req.ForceReprint {
+		key, window = idempotencyKey(r, req), opts.IdempotencyWindow
+	}
+
+	job, duplicate, err := s.queue.EnqueueUnique(key, window, req)
+	if err != nil {
+		response.Error(w, http.StatusInternalServerError, response.CodeInternal, err.Error())
+		return
+	}
+
+	if duplicate {
+		fmt.Printf("[DEBUG] Duplicate print for Order ID: %s, returning job %s\n", req.OrderID, job.ID)
+		response.JSON(w, http.StatusOK, printResponse{Status: job.State, JobID: job.ID, Duplicate: true})
+		return
+	}
+	fmt.Printf("[DEBUG] Job %s queued by %s for Order ID: %s\n", job.ID, client, req.OrderID)
+	response.JSON(w, http.StatusAccepted, printResponse{Status: job.State, JobID: job.ID})
+}
+
+// readBody reads the request body up to validate.MaxRequestBytes. It
+// writes the error response and returns false if the body is too large.
+func readBody(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, validate.MaxRequestBytes))
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		response.Error(w, http.StatusRequestEntityTooLarge, response.CodeRequestTooLarge,
+			fmt.Sprintf("Request body exceeds %d bytes", tooLarge.Limit))
+		return nil, false
+	case err != nil:
+		response.Error(w, http.StatusBadRequest, response.CodeInvalidRequest, "Cannot read request body")
+		return nil, false
+	}
+	return body, true
+}
+
+// verifySignature checks the HMAC signature when a signing secret is
+// configured. It writes the error response and returns false if the
+// request is unsigned, forged, stale or a replay. The returned nonce is
+// passed to useNonce once the request is accepted.
+func (s *Server) verifySignature(w http.ResponseWriter, r *http.Request, opts *Options, body []byte) (auth.Nonce, bool) {
+	if opts.SigningSecret == "" {
+		return auth.Nonce{}, true
+	}
+
+	nonce, err := s.verifier.Verify(opts.SigningSecret, opts.SigningWindow,
+		r.Header.Get(auth.TimestampHeader), r.Header.Get(auth.SignatureHeader), body)
+	if err != nil {
+		signatureRejected(w, r, err)
+		return auth.Nonce{}, false
+	}
+	return nonce, true
+}
+
+// useNonce uses up the signature of an accepted request. It writes the
+// error response and returns false if the same signed request was accepted
+// in the meantime.
+func (s *Server) useNonce(w http.ResponseWriter, r *http.Request, nonce auth.Nonce) bool {
+	if err := s.verifier.Use(nonce); err != nil {
+		signatureRejected(w, r, err)
+		return false
+	}
+	return true
+}
+
+func signatureRejected(w http.ResponseWriter, r *http.Request, err error) {
+	fmt.Printf("[AUTH] Rejected %s %s from %s: %v\n", r.Method, r.URL.Path, r.RemoteAddr, err)
+	response.Error(w, http.StatusUnauthorized, response.CodeBadSignature, err.Error())
+}
+
+// validationFailed writes a 422 response listing every invalid field
+func validationFailed(w http.ResponseWriter, err error) {
+	var fields validate.Errors
+	errors.As(err, &fields)
+
+	response.JSON(w, http.StatusUnprocessableEntity, struct {
+		response.ErrorBody
+		Errors validate.Errors `json:"errors"`
+	}{
+		ErrorBody: response.ErrorBody{
+			Status:  "error",
+			Code:    response.CodeValidationFailed,
+			Message: fmt.Sprintf("%d invalid field(s)", len(fields)),
+		},
+		Errors: fields,
+	})
+}
+
+type printResponse struct {
+	Status    string `json:"status"`
+	JobID     string `json:"job_id"`
+	Duplicate bool   `json:"duplicate,omitempty"` // The original job of a resubmission
+}
+
+// idempotencyKey returns the Idempotency-Key header, or a key derived from
+// the order ID, print mode and content so a double-clicked Print button
+// maps to the same job
+func idempotencyKey(r *http.Request, req render.PrintRequest) string {
+	if key := r.Header.Get("Idempotency-Key"); key != "" {
+		return "header:" + key
+	}
+
+	req.Token = ""
+	content, _ := json.Marshal(req)
+	sum := sha256.Sum256(content)
+
+	key := sha256.Sum256([]byte(req.OrderID + "\x00" + req.Mode() + "\x00" + hex.EncodeToString(sum[:])))
+	return "auto:" + hex.EncodeToString(key[:])
+}
+
+// printJob sends a queued job to the current printer, or its parts to the
+// printers they are routed to
+func (s *Server) printJob(job queue.Job) (string, error) {
+	opts := s.opts.Load()
+
+	parts := route(opts, job.Request)
+	if len(parts) > 1 {
+		return s.printParts(opts, job, parts)
+	}
+	if err := s.paused(parts[0].printer); err != nil {
+		return "", err
+	}
+	return s.printPart(opts, job, parts[0])
+}
+
+// Print sends data to the current printer right away, bypassing the
+// queue, e.g. for a test page
+func (s *Server) Print(data []byte) error {
+	t, err := s.opts.Load().Printer()
+	if err != nil {
+		return response.WithCode(response.CodePrinterNotFound, err)
+	}
+	return s.send(t, data)
+}
+
+// send prints data on t after checking the printer's status. If the status
+// monitor holds a connection to the same printer, that connection and the
+// status it last reported are used instead.
+func (s *Server) send(t transport.Transport, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	address := t.Status().Address
+	if conn, st := s.held(address); conn != nil {
+		if err := statusError(st); err != nil {
+			return err
+		}
+		if _, err := conn.Write(data); err != nil {
+			return printerError(&transport.OpError{Op: "write", Err: err})
+		}
+		return nil
+	}
+
+	err := transport.SendChecked(t, data, func() error {
+		st, err := s.queryStatus(t)
+		if err != nil || st == nil {
+			return err
+		}
+		if st.PaperNearEnd {
+			fmt.Printf("[STATUS] Paper is running low on %s\n", address)
+		}
+		return statusError(*st)
+	})
+	return printerError(err)
+}
+
+// queryStatus asks the open printer t for its real-time status. It returns
+// nil without an error if the transport or printer cannot report it.
+func (s *Server) queryStatus(t transport.Transport) (*escpos.Status, error) {
+	address := t.Status().Address
+	if since, ok := s.noStatus.Load(address); ok && time.Since(since.(time.Time)) < statusRetry {
+		return nil, nil
+	}
+
+	st, err := status.Query(t, statusTimeout)
+	switch {
+	case errors.Is(err, errors.ErrUnsupported):
+		return nil, nil
+	case errors.Is(err, transport.ErrReadTimeout):
+		fmt.Printf("[STATUS] %s does not report its status (%v), printing without status checks for %s\n", address, err, statusRetry)
+		s.noStatus.Store(address, time.Now())
+		return nil, nil
+	case err != nil:
+		return nil, err
+	}
+	s.noStatus.Delete(address)
+	return &st, nil
+}
+
+// statusError explains why a printer in state st cannot print, or returns
+// nil if it can
+func statusError(st escpos.Status) error {
+	switch {
+	case st.PaperOut:
+		return response.WithCode(response.CodePaperOut, errors.New("printer is out of paper"))
+	case st.CoverOpen:
+		return response.WithCode(response.CodeCoverOpen, errors.New("printer cover is open"))
+	case st.CutterError:
+		return response.WithCode(response.CodePrinterError, errors.New("printer reports a cutter error"))
+	case st.Unrecoverable:
+		return response.WithCode(response.CodePrinterError, errors.New("printer reports an unrecoverable error, turn it off and on again"))
+	case !st.Online:
+		return response.WithCode(response.CodePrinterOffline, errors.New("printer is offline"))
+	}
+	return nil
+}
+
+// printerError tags an error from transport.Send with a response code
+func printerError(err error) error {
+	var op *transport.OpError
+	switch {
+	case err == nil:
+		return nil
+	case response.CodeOf(err) != response.CodeInternal:
+		return err
+	case errors.As(err, &op) && op.Op == "open":
+		if errors.Is(err, os.ErrNotExist) {
+			return response.WithCode(response.CodePrinterNotFound, err)
+		}
+		return response.WithCode(response.CodePrinterOffline, err)
+	default:
+		return response.WithCode(response.CodeWriteFailed, err)
+	}
+}
+
+// authenticate checks the bearer key, or the body token when legacy body
+// tokens are enabled, and returns who sent the request for the log
+func authenticate(opts *Options, r *http.Request, bodyToken string) (string, bool) {
+	if secret, ok := auth.Bearer(r); ok {
+		key, ok := auth.Match(opts.Keys, secret)
+		return "key " + strconv.Quote(key.Name), ok
+	}
+
+	if bodyToken == "" {
+		return "", false
+	}
+	ok := false
+	for _, t := range opts.BodyTokens {
+		if auth.Equal(bodyToken, t) {
+			ok = true
+		}
+	}
+	return "legacy body token", ok
+}
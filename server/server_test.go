@@ -0,0 +1,660 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"cleanlink/printer/auth"
+	"cleanlink/printer/config"
+	"cleanlink/printer/cors"
+	"cleanlink/printer/queue"
+	"cleanlink/printer/render"
+	"cleanlink/printer/response"
+	"cleanlink/printer/transport"
+)
+
+const testToken = "test-token"
+
+func newTestServer(t *testing.T) (*Server, *transport.Memory) {
+	t.Helper()
+
+	q, err := queue.Open(queue.Options{Backoff: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mem := transport.NewMemory()
+	s := New(Options{
+		Keys:       []auth.Key{{Name: "test", Hash: auth.Hash(testToken)}},
+		BodyTokens: []string{testToken},
+		Printer:    func() (transport.Transport, error) { return mem, nil },
+	}, q)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.Start(ctx)
+	t.Cleanup(func() {
+		cancel()
+		s.workers.Wait()
+	})
+	return s, mem
+}
+
+// enqueue posts body to /print and returns the job ID
+func enqueue(t *testing.T, s *Server, body string) string {
+	t.Helper()
+
+	rec := do(t, s.Handler(), http.MethodPost, "/print", body)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body)
+	}
+
+	var resp map[string]string
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp["status"] != queue.StateQueued || resp["job_id"] == "" {
+		t.Fatalf("unexpected response %v", resp)
+	}
+	return resp["job_id"]
+}
+
+// waitJob waits until the job reaches state
+func waitJob(t *testing.T, s *Server, id, state string) queue.Job {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		job, err := s.queue.Get(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if job.State == state {
+			return job
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job %s is %s, want %s (last error %q)", id, job.State, state, job.LastError)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func do(t *testing.T, h http.Handler, method, path, body string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestPrintSendsRenderedReceipt(t *testing.T) {
+	s, mem := newTestServer(t)
+
+	req := render.PrintRequest{
+		Token:     testToken,
+		Title:     "Smart Laundry",
+		Body:      "Nama : Bu Kayam\n",
+		PrintMode: render.ModeReceiptOnly,
+	}
+	body, _ := json.Marshal(req)
+
+	id := enqueue(t, s, string(body))
+	job := waitJob(t, s, id, queue.StatePrinted)
+	if job.Printer != mem.Status().Address || job.Attempts != 1 {
+		t.Errorf("unexpected job %+v", job)
+	}
+
+	if got, want := mem.Bytes(), render.Render(req); !bytes.Equal(got, want) {
+		t.Errorf("printer received %q, want %q", got, want)
+	}
+	if n := len(mem.Writes()); n != 1 {
+		t.Errorf("receipt sent in %d writes, want 1", n)
+	}
+}
+
+func TestPrintIgnoresReprintFlag(t *testing.T) {
+	s, mem := newTestServer(t)
+
+	id := enqueue(t, s, `{"token":"`+testToken+`","title":"Smart Laundry","print_mode":"receipt-only","reprint":true}`)
+	job := waitJob(t, s, id, queue.StatePrinted)
+	if job.Request.Reprint || job.ReprintOf != "" {
+		t.Errorf("original stored as a reprint: %+v", job)
+	}
+	if bytes.Contains(mem.Bytes(), []byte("REPRINT")) {
+		t.Errorf("original printed with a REPRINT banner: %q", mem.Bytes())
+	}
+}
+
+func TestPrintRejectsBadToken(t *testing.T) {
+	s, mem := newTestServer(t)
+
+	rec := do(t, s.Handler(), http.MethodPost, "/print", `{"token":"wrong","title":"x"}`)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("status = %d, want 401", rec.Code)
+	}
+	assertCode(t, rec, response.CodeUnauthorized)
+	if len(mem.Bytes()) != 0 {
+		t.Error("unauthorized request reached the printer")
+	}
+}
+
+func TestPrintRetriesUntilPrinterOnline(t *testing.T) {
+	s, mem := newTestServer(t)
+
+	var online atomic.Bool
+	s.SetOptions(Options{
+		BodyTokens: []string{testToken},
+		Printer: func() (transport.Transport, error) {
+			if !online.Load() {
+				return nil, errors.New("offline")
+			}
+			return mem, nil
+		},
+	})
+
+	id := enqueue(t, s, `{"token":"`+testToken+`","title":"Smart Laundry"}`)
+
+	// Wait for a failed attempt, then bring the printer back
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		job, _ := s.queue.Get(id)
+		if job.LastError == "offline" {
+			if job.ErrorCode != string(response.CodePrinterNotFound) {
+				t.Errorf("error_code = %q", job.ErrorCode)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("job never failed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	online.Store(true)
+
+	job := waitJob(t, s, id, queue.StatePrinted)
+	if job.Attempts < 2 || job.LastError != "" || job.ErrorCode != "" {
+		t.Errorf("unexpected job %+v", job)
+	}
+	if len(mem.Bytes()) == 0 {
+		t.Error("receipt not printed")
+	}
+}
+
+func TestCheck(t *testing.T) {
+	s := New(Options{
+		Printer: func() (transport.Transport, error) { return nil, ErrNoPrinter },
+	}, nil)
+
+	rec := do(t, s.Handler(), http.MethodGet, "/check", "")
+
+	var resp map[string]string
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp["status"] != "error" || resp["message"] != ErrNoPrinter.Error() || resp["code"] != string(response.CodePrinterNotFound) {
+		t.Errorf("unexpected response %v", resp)
+	}
+}
+
+func TestPreflight(t *testing.T) {
+	s, _ := newTestServer(t)
+	origins, _ := cors.Parse([]string{"https://*.cleanlink.com"})
+	opts := *s.opts.Load()
+	opts.Origins = origins
+	s.SetOptions(opts)
+
+	preflight := func(origin string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodOptions, "/print", nil)
+		req.Header.Set("Origin", origin)
+		req.Header.Set("Access-Control-Request-Method", "POST")
+		req.Header.Set("Access-Control-Request-Private-Network", "true")
+		rec := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := preflight("https://pos.cleanlink.com")
+	if rec.Code != http.StatusOK {
+		t.Errorf("status = %d, want 200", rec.Code)
+	}
+	h := rec.Header()
+	if h.Get("Access-Control-Allow-Origin") != "https://pos.cleanlink.com" || h.Get("Vary") != "Origin" {
+		t.Errorf("origin not echoed: %v", h)
+	}
+	if h.Get("Access-Control-Allow-Private-Network") != "true" {
+		t.Error("private network access not allowed")
+	}
+
+	rec = preflight("https://evil.example")
+	if rec.Code != http.StatusForbidden || rec.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("other origin: status = %d, headers = %v", rec.Code, rec.Header())
+	}
+	assertCode(t, rec, response.CodeOriginNotAllowed)
+
+	// A page on another origin cannot print even with a simple request
+	req := httptest.NewRequest(http.MethodPost, "/print", strings.NewReader(`{"token":"`+testToken+`","title":"x"}`))
+	req.Header.Set("Origin", "https://evil.example")
+	rec = httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, req)
+	if rec.Code != http.StatusForbidden || len(s.queue.Jobs(nil)) != 0 {
+		t.Errorf("print from other origin: status = %d", rec.Code)
+	}
+}
+
+func TestSetOptions(t *testing.T) {
+	s, old := newTestServer(t)
+	mem := transport.NewMemory()
+
+	s.SetOptions(Options{
+		BodyTokens: []string{"new-token"},
+		Printer:    func() (transport.Transport, error) { return mem, nil },
+	})
+
+	if rec := do(t, s.Handler(), http.MethodPost, "/print", `{"token":"`+testToken+`","title":"Smart Laundry"}`); rec.Code != http.StatusUnauthorized {
+		t.Errorf("old token: status = %d, want 401", rec.Code)
+	}
+	id := enqueue(t, s, `{"token":"new-token","title":"Smart Laundry"}`)
+	waitJob(t, s, id, queue.StatePrinted)
+	if len(old.Bytes()) != 0 || len(mem.Bytes()) == 0 {
+		t.Error("job not sent to the new printer")
+	}
+}
+
+func TestOptionsFrom(t *testing.T) {
+	cfg := config.Default()
+	cfg.PaperWidth = 80
+	cfg.Printers = map[string]transport.Config{"labels": {Type: transport.KindMemory}}
+	cfg.Routes = map[string]string{render.ModeQROnly: "labels"}
+
+	mem := transport.NewMemory()
+	opts := OptionsFrom(&cfg, func() (transport.Transport, error) { return mem, nil })
+
+	if opts.Renderer.Options().Width != 48 || opts.SigningWindow != 5*time.Minute || opts.IdempotencyWindow != 10*time.Minute || !opts.StatusMonitor {
+		t.Errorf("settings not carried over: %+v", opts)
+	}
+	if opts.BodyTokens != nil {
+		t.Errorf("body tokens accepted without legacy_body_token: %v", opts.BodyTokens)
+	}
+	if opts.Routes[render.ModeQROnly] != "labels" || opts.Printers["labels"] == nil {
+		t.Errorf("routes not carried over: %v", opts.Routes)
+	}
+	if p, _ := opts.Printer(); p != mem {
+		t.Error("jobs not sent to the given printer")
+	}
+}
+
+func TestPrintIdempotency(t *testing.T) {
+	s, mem := newTestServer(t)
+	s.SetOptions(Options{
+		BodyTokens:        []string{testToken},
+		Printer:           func() (transport.Transport, error) { return mem, nil },
+		IdempotencyWindow: time.Minute,
+	})
+
+	body := `{"token":"` + testToken + `","title":"Smart Laundry","order_id":"ORD-1","body":"Nama : Bu Kayam\n"}`
+	first := enqueue(t, s, body)
+
+	// A double click returns the original job
+	rec := do(t, s.Handler(), http.MethodPost, "/print", body)
+	var resp printResponse
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	if rec.Code != http.StatusOK || resp.JobID != first || !resp.Duplicate {
+		t.Errorf("duplicate: status = %d, response = %+v", rec.Code, resp)
+	}
+
+	// Different content or an explicit reprint creates a new job
+	if id := enqueue(t, s, `{"token":"`+testToken+`","title":"Smart Laundry","order_id":"ORD-1","body":"Nama : Bu Ani\n"}`); id == first {
+		t.Error("changed content treated as duplicate")
+	}
+	forced := enqueue(t, s, `{"token":"`+testToken+`","title":"Smart Laundry","order_id":"ORD-1","body":"Nama : Bu Kayam\n","force_reprint":true}`)
+	if forced == first {
+		t.Error("force_reprint returned the original job")
+	}
+
+	// The Idempotency-Key header takes precedence over the content
+	post := func(key, body string) printResponse {
+		req := httptest.NewRequest(http.MethodPost, "/print", strings.NewReader(body))
+		req.Header.Set("Idempotency-Key", key)
+		rec := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rec, req)
+
+		var resp printResponse
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		return resp
+	}
+	a := post("click-1", `{"token":"`+testToken+`","title":"Smart Laundry","order_id":"ORD-2"}`)
+	b := post("click-1", `{"token":"`+testToken+`","title":"Smart Laundry","order_id":"ORD-2","body":"edited"}`)
+	c := post("click-2", `{"token":"`+testToken+`","title":"Smart Laundry","order_id":"ORD-2"}`)
+	if a.JobID == "" || b.JobID != a.JobID || !b.Duplicate || c.JobID == a.JobID {
+		t.Errorf("header keys: %+v %+v %+v", a, b, c)
+	}
+}
+
+// assertCode checks that rec is a JSON error with the given code
+func assertCode(t *testing.T, rec *httptest.ResponseRecorder, code response.Code) {
+	t.Helper()
+
+	var body response.ErrorBody
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("error body %q is not JSON: %v", rec.Body, err)
+	}
+	if body.Status != "error" || body.Code != code {
+		t.Errorf("error = %+v, want code %s", body, code)
+	}
+}
+
+func TestPrintInvalidJSON(t *testing.T) {
+	s, _ := newTestServer(t)
+
+	rec := do(t, s.Handler(), http.MethodPost, "/print", `{"token":`)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400", rec.Code)
+	}
+	assertCode(t, rec, response.CodeInvalidJSON)
+}
+
+func TestUnknownRoutes(t *testing.T) {
+	s, _ := newTestServer(t)
+
+	tests := []struct {
+		method, path string
+		status       int
+		code         response.Code
+		allow        string
+	}{
+		{http.MethodGet, "/nope", http.StatusNotFound, response.CodeNotFound, ""},
+		{http.MethodGet, "/jobs/x/reprint", http.StatusMethodNotAllowed, response.CodeMethodNotAllowed, "POST"},
+		{http.MethodPost, "/jobs", http.StatusMethodNotAllowed, response.CodeMethodNotAllowed, "GET"},
+		{http.MethodDelete, "/jobs/x", http.StatusMethodNotAllowed, response.CodeMethodNotAllowed, "GET"},
+		{http.MethodPost, "/cert", http.StatusMethodNotAllowed, response.CodeMethodNotAllowed, "GET"},
+		{http.MethodPost, "/events", http.StatusMethodNotAllowed, response.CodeMethodNotAllowed, "GET"},
+	}
+	for _, tt := range tests {
+		rec := do(t, s.Handler(), tt.method, tt.path, "")
+		if rec.Code != tt.status {
+			t.Errorf("%s %s: status = %d, want %d", tt.method, tt.path, rec.Code, tt.status)
+		}
+		if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+			t.Errorf("%s %s: Content-Type = %q", tt.method, tt.path, ct)
+		}
+		if allow := rec.Header().Get("Allow"); allow != tt.allow {
+			t.Errorf("%s %s: Allow = %q, want %q", tt.method, tt.path, allow, tt.allow)
+		}
+		assertCode(t, rec, tt.code)
+	}
+}
+
+func TestPrinterError(t *testing.T) {
+	tests := []struct {
+		err  error
+		code response.Code
+	}{
+		{&transport.OpError{Op: "open", Err: os.ErrNotExist}, response.CodePrinterNotFound},
+		{&transport.OpError{Op: "open", Err: errors.New("Access is denied.")}, response.CodePrinterOffline},
+		{&transport.OpError{Op: "write", Err: errors.New("broken pipe")}, response.CodeWriteFailed},
+		{response.WithCode(response.CodePaperOut, errors.New("paper out")), response.CodePaperOut},
+	}
+	for _, tt := range tests {
+		if got := response.CodeOf(printerError(tt.err)); got != tt.code {
+			t.Errorf("printerError(%v) code = %s, want %s", tt.err, got, tt.code)
+		}
+	}
+	if printerError(nil) != nil {
+		t.Error("printerError(nil) is not nil")
+	}
+}
+
+func TestPrintValidation(t *testing.T) {
+	s, mem := newTestServer(t)
+
+	rec := do(t, s.Handler(), http.MethodPost, "/print", `{"token":"`+testToken+`","print_mode":"poster","qr_codes":[{}]}`)
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("status = %d, want 422", rec.Code)
+	}
+	assertCode(t, rec, response.CodeValidationFailed)
+
+	var body struct {
+		Errors []map[string]string `json:"errors"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &body)
+	if len(body.Errors) != 3 {
+		t.Errorf("errors = %v, want print_mode and both qr_codes[0] fields", body.Errors)
+	}
+	if len(s.queue.Jobs(nil)) != 0 || len(mem.Bytes()) != 0 {
+		t.Error("invalid request was queued")
+	}
+}
+
+func TestPrintTooLarge(t *testing.T) {
+	s, _ := newTestServer(t)
+
+	body := `{"token":"` + testToken + `","title":"` + strings.Repeat("x", 70<<10) + `"}`
+	rec := do(t, s.Handler(), http.MethodPost, "/print", body)
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("status = %d, want 413", rec.Code)
+	}
+	assertCode(t, rec, response.CodeRequestTooLarge)
+}
+
+func TestPrintWithAPIKey(t *testing.T) {
+	s, _ := newTestServer(t)
+	s.SetOptions(Options{
+		Keys: []auth.Key{
+			{Name: "Kasir 1", Hash: auth.Hash("key-1")},
+			{Name: "Kasir 2", Hash: auth.Hash("key-2"), Revoked: true},
+		},
+		Printer: s.opts.Load().Printer,
+	})
+
+	post := func(key, body string) int {
+		req := httptest.NewRequest(http.MethodPost, "/print", strings.NewReader(body))
+		if key != "" {
+			req.Header.Set("Authorization", "Bearer "+key)
+		}
+		rec := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := post("key-1", `{"title":"Smart Laundry"}`); code != http.StatusAccepted {
+		t.Errorf("active key: status = %d, want 202", code)
+	}
+	if code := post("key-2", `{"title":"Smart Laundry"}`); code != http.StatusUnauthorized {
+		t.Errorf("revoked key: status = %d, want 401", code)
+	}
+	// Body tokens are off unless legacy_body_token is enabled
+	if code := post("", `{"token":"`+testToken+`","title":"Smart Laundry"}`); code != http.StatusUnauthorized {
+		t.Errorf("body token: status = %d, want 401", code)
+	}
+}
+
+func TestPrintRequiresSignature(t *testing.T) {
+	s, _ := newTestServer(t)
+	opts := *s.opts.Load()
+	opts.SigningSecret = "backend-shared-secret"
+	opts.SigningWindow = time.Minute
+	s.SetOptions(opts)
+
+	body := `{"token":"` + testToken + `","title":"Smart Laundry","order_id":"ORD-1"}`
+	post := func(ts int64, sig, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/print", strings.NewReader(body))
+		req.Header.Set(auth.TimestampHeader, strconv.FormatInt(ts, 10))
+		req.Header.Set(auth.SignatureHeader, sig)
+		rec := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rec, req)
+		return rec
+	}
+
+	now := time.Now().Unix()
+	sig := auth.Sign(opts.SigningSecret, now, []byte(body))
+
+	if rec := do(t, s.Handler(), http.MethodPost, "/print", body); rec.Code != http.StatusUnauthorized {
+		t.Errorf("unsigned: status = %d, want 401", rec.Code)
+	} else {
+		assertCode(t, rec, response.CodeBadSignature)
+	}
+	if rec := post(now, sig, strings.Replace(body, "ORD-1", "ORD-2", 1)); rec.Code != http.StatusUnauthorized {
+		t.Errorf("tampered: status = %d, want 401", rec.Code)
+	}
+	old := now - 120
+	if rec := post(old, auth.Sign(opts.SigningSecret, old, []byte(body)), body); rec.Code != http.StatusUnauthorized {
+		t.Errorf("stale: status = %d, want 401", rec.Code)
+	}
+
+	if rec := post(now, sig, body); rec.Code != http.StatusAccepted {
+		t.Fatalf("signed: status = %d, body = %s", rec.Code, rec.Body)
+	}
+	if rec := post(now, sig, body); rec.Code != http.StatusUnauthorized {
+		t.Errorf("replayed: status = %d, want 401", rec.Code)
+	}
+
+	if n := len(s.queue.Jobs(nil)); n != 1 {
+		t.Errorf("%d jobs queued, want 1", n)
+	}
+}
+
+func TestSignedRequestRetriedAfterRejection(t *testing.T) {
+	s, _ := newTestServer(t)
+	opts := *s.opts.Load()
+	opts.SigningSecret = "backend-shared-secret"
+	opts.SigningWindow = time.Minute
+	s.SetOptions(opts)
+
+	now := time.Now().Unix()
+	post := func(key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/print", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+key)
+		req.Header.Set(auth.TimestampHeader, strconv.FormatInt(now, 10))
+		req.Header.Set(auth.SignatureHeader, auth.Sign(opts.SigningSecret, now, []byte(body)))
+		rec := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rec, req)
+		return rec
+	}
+
+	// A correctly signed request turned away for its key can be sent
+	// again once the key is fixed
+	body := `{"title":"Smart Laundry","order_id":"ORD-1"}`
+	if rec := post("wrong-key", body); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("wrong key: status = %d, want 401", rec.Code)
+	}
+	if rec := post(testToken, body); rec.Code != http.StatusAccepted {
+		t.Fatalf("retry: status = %d, body = %s", rec.Code, rec.Body)
+	}
+	if rec := post(testToken, body); rec.Code != http.StatusUnauthorized {
+		t.Errorf("replayed: status = %d, want 401", rec.Code)
+	}
+}
+
+func TestIPFilter(t *testing.T) {
+	s, _ := newTestServer(t)
+	opts := *s.opts.Load()
+	opts.AllowedIPs = []netip.Prefix{netip.MustParsePrefix("192.168.1.0/24")}
+	s.SetOptions(opts)
+
+	for remote, want := range map[string]int{
+		"192.168.1.20:50000":          http.StatusOK,
+		"127.0.0.1:50000":             http.StatusOK,
+		"[::1]:50000":                 http.StatusOK,
+		"[::ffff:192.168.1.20]:50000": http.StatusOK,
+		"192.168.2.20:50000":          http.StatusForbidden,
+		"10.0.0.1:50000":              http.StatusForbidden,
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/ping", nil)
+		req.RemoteAddr = remote
+		rec := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rec, req)
+		if rec.Code != want {
+			t.Errorf("%s: status = %d, want %d", remote, rec.Code, want)
+		}
+	}
+}
+
+func TestLANCheckAndEventsNeedKey(t *testing.T) {
+	s, _ := newTestServer(t)
+	opts := *s.opts.Load()
+	opts.AllowedIPs = []netip.Prefix{netip.MustParsePrefix("192.168.1.0/24")}
+	s.SetOptions(opts)
+
+	get := func(path, remote, key string) int {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr = remote
+		if key != "" {
+			req.Header.Set("Authorization", "Bearer "+key)
+		}
+		rec := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	for _, path := range []string{"/check", "/events"} {
+		if code := get(path, "192.168.1.20:50000", ""); code != http.StatusUnauthorized {
+			t.Errorf("%s from the LAN without a key: status = %d, want 401", path, code)
+		}
+		if code := get(path, "192.168.1.20:50000", "wrong"); code != http.StatusUnauthorized {
+			t.Errorf("%s from the LAN with a wrong key: status = %d, want 401", path, code)
+		}
+	}
+	if code := get("/check", "192.168.1.20:50000", testToken); code != http.StatusOK {
+		t.Errorf("/check from the LAN with a key: status = %d, want 200", code)
+	}
+	if code := get("/check", "127.0.0.1:50000", ""); code != http.StatusOK {
+		t.Errorf("/check from this PC: status = %d, want 200", code)
+	}
+}
+
+func TestIPFilterWithoutLAN(t *testing.T) {
+	// A listener bound to the network with no allowed_ips, e.g. after lan
+	// was turned off without a restart, only serves this PC
+	s, _ := newTestServer(t)
+
+	for _, tt := range []struct {
+		local, remote string
+		want          int
+	}{
+		{"192.168.1.5:3491", "192.168.1.20:50000", http.StatusForbidden},
+		{"127.0.0.1:3491", "127.0.0.1:50000", http.StatusOK},
+		{"[::1]:3491", "[::1]:50000", http.StatusOK},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/ping", nil)
+		req.RemoteAddr = tt.remote
+		local := net.TCPAddrFromAddrPort(netip.MustParseAddrPort(tt.local))
+		req = req.WithContext(context.WithValue(req.Context(), http.LocalAddrContextKey, local))
+		rec := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("%s -> %s: status = %d, want %d", tt.remote, tt.local, rec.Code, tt.want)
+		}
+	}
+}
+
+func TestCert(t *testing.T) {
+	s, _ := newTestServer(t)
+
+	if rec := do(t, s.Handler(), http.MethodGet, "/cert", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("without HTTPS: status = %d, want 404", rec.Code)
+	} else {
+		assertCode(t, rec, response.CodeNotFound)
+	}
+
+	pem := []byte("-----BEGIN CERTIFICATE-----\n...\n-----END CERTIFICATE-----\n")
+	s.SetCACert(pem)
+	rec := do(t, s.Handler(), http.MethodGet, "/cert", "")
+	if rec.Code != http.StatusOK || !bytes.Equal(rec.Body.Bytes(), pem) {
+		t.Errorf("status = %d, body = %q", rec.Code, rec.Body)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/x-x509-ca-cert" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+}
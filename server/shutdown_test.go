@@ -0,0 +1,119 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"cleanlink/printer/escpos"
+	"cleanlink/printer/queue"
+	"cleanlink/printer/transport"
+)
+
+// stuckPrinter accepts the connection but blocks every write until release
+// is closed, like a Bluetooth printer that went out of range mid-job
+type stuckPrinter struct {
+	*transport.Memory
+	release chan struct{}
+}
+
+func (p *stuckPrinter) Write(b []byte) (int, error) {
+	<-p.release
+	return p.Memory.Write(b)
+}
+
+func startServer(t *testing.T, printer transport.Transport) (*Server, context.CancelFunc) {
+	t.Helper()
+
+	q, _ := queue.Open(queue.Options{})
+	s := New(Options{
+		BodyTokens: []string{testToken},
+		Printer:    func() (transport.Transport, error) { return printer, nil },
+	}, q)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.Start(ctx)
+	t.Cleanup(cancel)
+	return s, cancel
+}
+
+func TestShutdownResetsPrinter(t *testing.T) {
+	mem := transport.NewMemory()
+	s, stop := startServer(t, mem)
+
+	id := enqueue(t, s, `{"token":"`+testToken+`","title":"Smart Laundry"}`)
+	waitJob(t, s, id, queue.StatePrinted)
+
+	stop()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	s.Shutdown(ctx)
+
+	writes := mem.Writes()
+	if last := writes[len(writes)-1]; !bytes.Equal(last, escpos.New().Init().Bytes()) {
+		t.Errorf("last write = %q, want ESC @", last)
+	}
+}
+
+func TestShutdownRequeuesStuckJob(t *testing.T) {
+	defer func(d time.Duration) { resetTimeout = d }(resetTimeout)
+	resetTimeout = 50 * time.Millisecond
+
+	printer := &stuckPrinter{Memory: transport.NewMemory(), release: make(chan struct{})}
+	defer close(printer.release)
+	s, stop := startServer(t, printer)
+
+	id := enqueue(t, s, `{"token":"`+testToken+`","title":"Smart Laundry"}`)
+	waitJob(t, s, id, queue.StatePrinting)
+
+	stop()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	s.Shutdown(ctx)
+
+	if job, _ := s.queue.Get(id); job.State != queue.StateQueued {
+		t.Errorf("stuck job is %s, want queued", job.State)
+	}
+}
+
+func TestListenAndServeStops(t *testing.T) {
+	s, _ := startServer(t, transport.NewMemory())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() { errc <- s.ListenAndServe(ctx, Listener{Addr: "127.0.0.1:0"}) }()
+
+	cancel()
+	select {
+	case err := <-errc:
+		if err != nil {
+			t.Errorf("ListenAndServe() = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("ListenAndServe did not return after cancel")
+	}
+}
+
+func TestShutdownRightAfterStart(t *testing.T) {
+	// The workers are registered by Start, so Shutdown cannot return
+	// before they have stopped
+	mem := transport.NewMemory()
+	s, stop := startServer(t, mem)
+	stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	s.Shutdown(ctx)
+
+	done := make(chan struct{})
+	go func() {
+		s.workers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Millisecond):
+		t.Error("workers still running after Shutdown")
+	}
+}
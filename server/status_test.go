@@ -0,0 +1,176 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"cleanlink/printer/escpos"
+	"cleanlink/printer/queue"
+	"cleanlink/printer/response"
+	"cleanlink/printer/transport"
+)
+
+// Replies to DLE EOT 1-4
+var (
+	replyReady    = [4]byte{0x16, 0x12, 0x12, 0x12}
+	replyPaperOut = [4]byte{0x1E, 0x32, 0x12, 0x7E}
+	replyCover    = [4]byte{0x1E, 0x16, 0x12, 0x12}
+	replyLowPaper = [4]byte{0x16, 0x12, 0x12, 0x1E}
+)
+
+// statusPrinter replaces the test server's printer with one that answers
+// status requests with *replies
+func statusPrinter(t *testing.T, s *Server, replies *atomic.Pointer[[4]byte]) *transport.Replier {
+	t.Helper()
+
+	p := transport.NewReplier(func(b []byte) []byte {
+		if len(b) == 3 && b[0] == escpos.DLE && b[1] == escpos.EOT && b[2] >= 1 && b[2] <= 4 {
+			if r := replies.Load(); r != nil {
+				return []byte{r[b[2]-1]}
+			}
+		}
+		return nil
+	})
+	opts := *s.opts.Load()
+	opts.Printer = func() (transport.Transport, error) { return p, nil }
+	s.SetOptions(opts)
+	return p
+}
+
+func TestCheckPrinterStatus(t *testing.T) {
+	s, _ := newTestServer(t)
+	var replies atomic.Pointer[[4]byte]
+	statusPrinter(t, s, &replies)
+
+	check := func() (resp checkResponse) {
+		t.Helper()
+		rec := do(t, s.Handler(), http.MethodGet, "/check", "")
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	replies.Store(&replyPaperOut)
+	resp := check()
+	if resp.Status != "error" || resp.Code != response.CodePaperOut || resp.Printer == nil || !resp.Printer.PaperOut {
+		t.Errorf("unexpected response %+v", resp)
+	}
+
+	replies.Store(&replyCover)
+	if resp := check(); resp.Code != response.CodeCoverOpen {
+		t.Errorf("code = %q, want %s", resp.Code, response.CodeCoverOpen)
+	}
+
+	replies.Store(&replyLowPaper)
+	resp = check()
+	if resp.Status != "ok" || resp.Printer == nil || !resp.Printer.Online || !resp.Printer.PaperNearEnd {
+		t.Errorf("unexpected response %+v", resp)
+	}
+}
+
+// resetPrinter accepts data but its connection drops when read
+type resetPrinter struct {
+	*transport.Memory
+}
+
+func (p resetPrinter) ReadTimeout([]byte, time.Duration) (int, error) {
+	return 0, errors.New("connection reset by peer")
+}
+
+func TestCheckStatusQueryFails(t *testing.T) {
+	// The printer was opened, so a failed status query is not reported as
+	// an offline or missing printer
+	s, _ := newTestServer(t)
+	opts := *s.opts.Load()
+	opts.Printer = func() (transport.Transport, error) { return resetPrinter{transport.NewMemory()}, nil }
+	s.SetOptions(opts)
+
+	var resp checkResponse
+	rec := do(t, s.Handler(), http.MethodGet, "/check", "")
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != "error" || resp.Code != response.CodeWriteFailed || !strings.Contains(resp.Message, "connection reset") {
+		t.Errorf("unexpected response %+v", resp)
+	}
+}
+
+func TestPrintChecksStatus(t *testing.T) {
+	s, _ := newTestServer(t)
+	var replies atomic.Pointer[[4]byte]
+	replies.Store(&replyCover)
+	p := statusPrinter(t, s, &replies)
+
+	id := enqueue(t, s, `{"token":"`+testToken+`","title":"Smart Laundry"}`)
+
+	// Wait for the open cover to fail an attempt, then close it
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		job, _ := s.queue.Get(id)
+		if job.ErrorCode == string(response.CodeCoverOpen) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job never failed with COVER_OPEN: %+v", job)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if bytes.Contains(p.Bytes(), []byte("Smart Laundry")) {
+		t.Error("receipt sent while the cover was open")
+	}
+	replies.Store(&replyReady)
+
+	waitJob(t, s, id, queue.StatePrinted)
+	writes := p.Writes()
+	last := writes[len(writes)-1]
+	if !bytes.Contains(last, []byte("Smart Laundry")) {
+		t.Errorf("receipt not printed, last write %q", last)
+	}
+	if got := writes[len(writes)-2]; !bytes.Equal(got, escpos.StatusRequest(escpos.StatusPaper)) {
+		t.Errorf("status not checked before the receipt, got % X", got)
+	}
+}
+
+func TestPrintWithoutStatusReplies(t *testing.T) {
+	defer func(d time.Duration) { statusTimeout = d }(statusTimeout)
+	statusTimeout = 10 * time.Millisecond
+
+	s, _ := newTestServer(t)
+	var replies atomic.Pointer[[4]byte] // Never answers
+	p := statusPrinter(t, s, &replies)
+
+	for range 2 {
+		id := enqueue(t, s, `{"token":"`+testToken+`","title":"Smart Laundry"}`)
+		waitJob(t, s, id, queue.StatePrinted)
+	}
+
+	// Only the first job asks; the printer is then known not to answer
+	if n := bytes.Count(p.Bytes(), escpos.StatusRequest(escpos.StatusPrinter)); n != 1 {
+		t.Errorf("status requested %d times, want 1", n)
+	}
+
+	// After statusRetry the printer, awake now, is asked again
+	s.noStatus.Store(p.Status().Address, time.Now().Add(-statusRetry))
+	replies.Store(&replyPaperOut)
+	id := enqueue(t, s, `{"token":"`+testToken+`","title":"Smart Laundry"}`)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if job, _ := s.queue.Get(id); job.ErrorCode == string(response.CodePaperOut) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("status not checked again after statusRetry")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if _, ok := s.noStatus.Load(p.Status().Address); ok {
+		t.Error("printer still marked as not answering")
+	}
+}
@@ -0,0 +1,119 @@
+package status
+
+import (
+	"time"
+
+	"cleanlink/printer/escpos"
+)
+
+// Event types
+const (
+	EventPaperLow    = "paper_low"    // Roll paper near end
+	EventPaperOut    = "paper_out"    // No paper left
+	EventCoverOpened = "cover_opened" // Cover open
+	EventError       = "error"        // Cutter or unrecoverable error
+	EventOffline     = "offline"      // Offline for another reason, or connection lost
+	EventRecovered   = "recovered"    // Ready to print again
+)
+
+// Event is a change in a printer's status
+type Event struct {
+	Type    string        `json:"type"`
+	Printer string        `json:"printer"` // Printer address, e.g. "COM10"
+	Message string        `json:"message"`
+	Status  escpos.Status `json:"status"`
+	Time    time.Time     `json:"time"`
+}
+
+var messages = map[string]string{
+	EventPaperLow:    "Paper is running low",
+	EventPaperOut:    "Printer is out of paper",
+	EventCoverOpened: "Printer cover is open",
+	EventError:       "Printer reports an error",
+	EventOffline:     "Printer went offline",
+	EventRecovered:   "Printer is ready again",
+}
+
+// NewEvent returns an event of the given type with its message
+func NewEvent(typ, printer string, st escpos.Status) Event {
+	return Event{Type: typ, Printer: printer, Message: messages[typ], Status: st, Time: time.Now()}
+}
+
+// Tracker turns successive statuses of one printer into event types
+type Tracker struct {
+	last *escpos.Status
+}
+
+// Update records st and returns the types of the events it caused. A
+// printer is assumed to have been ready before its first status, so
+// problems present from the start are reported too.
+func (tr *Tracker) Update(st escpos.Status) []string {
+	prev := escpos.Status{Online: true}
+	if tr.last != nil {
+		prev = *tr.last
+	}
+	tr.last = &st
+
+	var events []string
+	rose := func(was, is bool) bool { return is && !was }
+	switch {
+	case rose(prev.PaperOut, st.PaperOut):
+		events = append(events, EventPaperOut)
+	case rose(prev.PaperNearEnd, st.PaperNearEnd) && !st.PaperOut:
+		events = append(events, EventPaperLow)
+	}
+	if rose(prev.CoverOpen, st.CoverOpen) {
+		events = append(events, EventCoverOpened)
+	}
+	if rose(prev.CutterError || prev.Unrecoverable, st.CutterError || st.Unrecoverable) {
+		events = append(events, EventError)
+	}
+	// Paper and cover problems take the printer offline too; only report
+	// going offline when nothing more specific explains it
+	if prev.Online && !st.Online && len(events) == 0 {
+		events = append(events, EventOffline)
+	}
+	if !prev.Ready() && st.Ready() {
+		events = append(events, EventRecovered)
+	}
+	return events
+}
+
+// Last returns the last recorded status, and false before the first Update
+func (tr *Tracker) Last() (escpos.Status, bool) {
+	if tr.last == nil {
+		return escpos.Status{}, false
+	}
+	return *tr.last, true
+}
+
+// Decoder splits what a printer with automatic status back enabled sends
+// into status blocks, skipping anything else such as XON/XOFF
+type Decoder struct {
+	buf []byte
+}
+
+// Feed adds bytes read from the printer and returns the statuses of the
+// blocks completed by them
+func (d *Decoder) Feed(p []byte) []escpos.Status {
+	d.buf = append(d.buf, p...)
+
+	var out []escpos.Status
+	for len(d.buf) > 0 {
+		if !escpos.IsASBHeader(d.buf[0]) {
+			d.buf = d.buf[1:]
+			continue
+		}
+		if len(d.buf) < 4 {
+			break
+		}
+		st, err := escpos.ParseASB([4]byte(d.buf))
+		if err != nil {
+			d.buf = d.buf[1:]
+			continue
+		}
+		out = append(out, st)
+		d.buf = d.buf[4:]
+	}
+	return out
+}
@@ -0,0 +1,62 @@
+package status
+
+import (
+	"slices"
+	"testing"
+
+	"cleanlink/printer/escpos"
+)
+
+func TestTracker(t *testing.T) {
+	ready := escpos.Status{Online: true}
+	steps := []struct {
+		status escpos.Status
+		want   []string
+	}{
+		{ready, nil},
+		{escpos.Status{Online: true, PaperNearEnd: true}, []string{EventPaperLow}},
+		{escpos.Status{PaperNearEnd: true, PaperOut: true}, []string{EventPaperOut}},
+		{ready, []string{EventRecovered}},
+		{escpos.Status{CoverOpen: true}, []string{EventCoverOpened}},
+		{escpos.Status{CoverOpen: true, CutterError: true}, []string{EventError}},
+		{ready, []string{EventRecovered}},
+		{escpos.Status{}, []string{EventOffline}},
+		{escpos.Status{}, nil},
+		{ready, []string{EventRecovered}},
+	}
+
+	var tr Tracker
+	if _, ok := tr.Last(); ok {
+		t.Error("Last() reported a status before the first Update")
+	}
+	for i, step := range steps {
+		if got := tr.Update(step.status); !slices.Equal(got, step.want) {
+			t.Errorf("step %d: Update(%v) = %v, want %v", i, step.status, got, step.want)
+		}
+	}
+}
+
+func TestTrackerStartsWithProblem(t *testing.T) {
+	var tr Tracker
+	got := tr.Update(escpos.Status{PaperOut: true})
+	if !slices.Equal(got, []string{EventPaperOut}) {
+		t.Errorf("Update() = %v, want [paper_out]", got)
+	}
+}
+
+func TestDecoder(t *testing.T) {
+	var d Decoder
+
+	// XOFF, then a block split across reads, then a second block
+	if got := d.Feed([]byte{0x13, 0x10, 0x00}); len(got) != 0 {
+		t.Fatalf("incomplete block decoded: %v", got)
+	}
+	got := d.Feed([]byte{0x0F, 0x00, 0x38, 0x00, 0x00, 0x00})
+	want := []escpos.Status{
+		{PaperNearEnd: true, PaperOut: true, Online: true},
+		{CoverOpen: true},
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("Feed() = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,64 @@
+// Package status asks the printer for its real-time status (DLE EOT) over
+// transports that can read replies, so the agent can report an empty roll
+// or an open cover instead of sending a job the printer will not print.
+package status
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"cleanlink/printer/escpos"
+	"cleanlink/printer/transport"
+)
+
+// DefaultTimeout is how long Query waits for each reply. Bluetooth printers
+// need a few hundred milliseconds.
+const DefaultTimeout = time.Second
+
+// Query sends DLE EOT 1-4 to the open transport t and decodes the replies.
+// It returns an error matching errors.ErrUnsupported if t cannot read, and
+// transport.ErrReadTimeout if the printer does not answer, which usually
+// means it does not implement DLE EOT.
+func Query(t transport.Transport, timeout time.Duration) (escpos.Status, error) {
+	var s escpos.Status
+
+	r, ok := t.(transport.Reader)
+	if !ok {
+		return s, fmt.Errorf("%s printers: %w", t.Status().Kind, errors.ErrUnsupported)
+	}
+
+	for n := escpos.StatusPrinter; n <= escpos.StatusPaper; n++ {
+		if _, err := t.Write(escpos.StatusRequest(n)); err != nil {
+			return s, err
+		}
+		b, err := readReply(r, timeout)
+		if err != nil {
+			return s, err
+		}
+		if err := s.Parse(n, b); err != nil {
+			return s, err
+		}
+	}
+	return s, nil
+}
+
+// readReply returns the next status reply byte, skipping anything else the
+// printer sends, such as XON/XOFF
+func readReply(r transport.Reader, timeout time.Duration) (byte, error) {
+	deadline := time.Now().Add(timeout)
+	buf := make([]byte, 1)
+	for {
+		wait := time.Until(deadline)
+		if wait <= 0 {
+			return 0, transport.ErrReadTimeout
+		}
+		n, err := r.ReadTimeout(buf, wait)
+		if err != nil {
+			return 0, err
+		}
+		if n == 1 && escpos.IsStatusReply(buf[0]) {
+			return buf[0], nil
+		}
+	}
+}
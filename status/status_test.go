@@ -0,0 +1,54 @@
+package status
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"cleanlink/printer/escpos"
+	"cleanlink/printer/transport"
+)
+
+// printer returns a memory transport that answers DLE EOT 1-4 with replies
+func printer(replies [4]byte) *transport.Replier {
+	m := transport.NewReplier(func(p []byte) []byte {
+		if len(p) == 3 && p[0] == escpos.DLE && p[1] == escpos.EOT && p[2] >= 1 && p[2] <= 4 {
+			// XOFF first, as printers with software flow control may send
+			return []byte{0x13, replies[p[2]-1]}
+		}
+		return nil
+	})
+	m.Open()
+	return m
+}
+
+func TestQuery(t *testing.T) {
+	m := printer([4]byte{0x1E, 0x36, 0x12, 0x7E})
+
+	got, err := Query(m, DefaultTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := escpos.Status{CoverOpen: true, PaperNearEnd: true, PaperOut: true}
+	if got != want {
+		t.Errorf("Query() = %+v, want %+v", got, want)
+	}
+}
+
+func TestQueryNoReply(t *testing.T) {
+	m := transport.NewMemory()
+	m.Open()
+	if _, err := Query(m, DefaultTimeout); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("Query() on a write-only transport = %v", err)
+	}
+
+	silent := transport.NewReplier(func([]byte) []byte { return nil })
+	silent.Open()
+	if _, err := Query(silent, 10*time.Millisecond); !errors.Is(err, transport.ErrReadTimeout) {
+		t.Errorf("Query() on a silent printer = %v", err)
+	}
+
+	if _, err := Query(transport.NewFile("/dev/null"), DefaultTimeout); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("Query() on a file = %v", err)
+	}
+}
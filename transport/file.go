@@ -0,0 +1,84 @@
+package transport
+
+import (
+	"errors"
+	"os"
+	"sync"
+)
+
+// fileTransport writes to printer device nodes and regular files
+type fileTransport struct {
+	kind    string
+	address string
+	path    string
+	flag    int
+
+	mu      sync.Mutex
+	f       *os.File
+	lastErr error
+}
+
+// NewFile returns a transport that appends to path, creating it if needed.
+// Useful for raw printer device nodes and for capturing jobs to disk.
+func NewFile(path string) Transport {
+	return &fileTransport{
+		kind:    KindFile,
+		address: path,
+		path:    path,
+		flag:    os.O_WRONLY | os.O_CREATE | os.O_APPEND,
+	}
+}
+
+func (t *fileTransport) Open() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.f != nil {
+		return nil
+	}
+
+	f, err := os.OpenFile(t.path, t.flag, 0666)
+	t.lastErr = err
+	if err != nil {
+		return err
+	}
+	t.f = f
+	return nil
+}
+
+func (t *fileTransport) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.f == nil {
+		return 0, errors.New("transport is not open")
+	}
+
+	n, err := t.f.Write(p)
+	t.lastErr = err
+	return n, err
+}
+
+func (t *fileTransport) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.f == nil {
+		return nil
+	}
+
+	err := t.f.Close()
+	t.f = nil
+	return err
+}
+
+func (t *fileTransport) Status() Status {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	s := Status{Kind: t.kind, Address: t.address, Open: t.f != nil}
+	if t.lastErr != nil {
+		s.LastError = t.lastErr.Error()
+	}
+	return s
+}
@@ -0,0 +1,166 @@
+package transport
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// Memory is an in-memory sink that records everything written to it, so
+// tests can assert on exactly what would have been sent to the printer
+type Memory struct {
+	mu     sync.Mutex
+	open   bool
+	writes [][]byte
+
+	// OpenErr, when set, is returned by Open to simulate an offline printer
+	OpenErr error
+}
+
+// NewMemory returns an empty in-memory sink
+func NewMemory() *Memory {
+	return &Memory{}
+}
+
+func (m *Memory) Open() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.OpenErr != nil {
+		return m.OpenErr
+	}
+	m.open = true
+	return nil
+}
+
+func (m *Memory) Write(p []byte) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.open {
+		return 0, errors.New("transport is not open")
+	}
+	m.writes = append(m.writes, append([]byte(nil), p...))
+	return len(p), nil
+}
+
+func (m *Memory) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.open = false
+	return nil
+}
+
+func (m *Memory) Status() Status {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s := Status{Kind: KindMemory, Address: "memory", Open: m.open}
+	if m.OpenErr != nil {
+		s.LastError = m.OpenErr.Error()
+	}
+	return s
+}
+
+// Writes returns a copy of every Write call in order
+func (m *Memory) Writes() [][]byte {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	out := make([][]byte, len(m.writes))
+	copy(out, m.writes)
+	return out
+}
+
+// Bytes returns everything written so far as one stream
+func (m *Memory) Bytes() []byte {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var out []byte
+	for _, w := range m.writes {
+		out = append(out, w...)
+	}
+	return out
+}
+
+// Reset discards everything written so far
+func (m *Memory) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.writes = nil
+}
+
+// Replier is a Memory that also answers what is written to it, to simulate
+// a printer that replies to status requests
+type Replier struct {
+	*Memory
+	respond func(p []byte) []byte
+	unread  []byte
+	arrived chan struct{}
+}
+
+// NewReplier returns an in-memory printer whose replies to each write are
+// what respond returns for it
+func NewReplier(respond func(p []byte) []byte) *Replier {
+	return &Replier{Memory: NewMemory(), respond: respond, arrived: make(chan struct{}, 1)}
+}
+
+func (r *Replier) Write(p []byte) (int, error) {
+	n, err := r.Memory.Write(p)
+	if err == nil {
+		r.Push(r.respond(p))
+	}
+	return n, err
+}
+
+// Push queues bytes the printer sends on its own, such as an automatic
+// status block
+func (r *Replier) Push(p []byte) {
+	if len(p) == 0 {
+		return
+	}
+
+	r.mu.Lock()
+	r.unread = append(r.unread, p...)
+	r.mu.Unlock()
+
+	select {
+	case r.arrived <- struct{}{}:
+	default:
+	}
+}
+
+// ReadTimeout returns the queued replies, waiting up to timeout for some
+func (r *Replier) ReadTimeout(p []byte, timeout time.Duration) (int, error) {
+	deadline := time.After(timeout)
+	for {
+		r.mu.Lock()
+		switch {
+		case !r.open:
+			r.mu.Unlock()
+			return 0, errors.New("transport is not open")
+		case len(r.unread) > 0:
+			n := copy(p, r.unread)
+			r.unread = r.unread[n:]
+			r.mu.Unlock()
+			return n, nil
+		}
+		r.mu.Unlock()
+
+		select {
+		case <-r.arrived:
+		case <-deadline:
+			return 0, ErrReadTimeout
+		}
+	}
+}
+
+func (r *Replier) Close() error {
+	r.mu.Lock()
+	r.unread = nil
+	r.mu.Unlock()
+	return r.Memory.Close()
+}
@@ -0,0 +1,181 @@
+package transport
+
+import (
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefaultNetworkPort is the raw printing (JetDirect) port used by
+// Ethernet and Wi-Fi ESC/POS printers
+const DefaultNetworkPort = "9100"
+
+// Network timeouts used when the config leaves them empty
+const (
+	DefaultConnectTimeout = 5 * time.Second
+	DefaultWriteTimeout   = 10 * time.Second
+	DefaultRetries        = 3
+)
+
+// NetworkOptions tunes a network transport
+type NetworkOptions struct {
+	ConnectTimeout time.Duration // Per dial attempt
+	WriteTimeout   time.Duration // For a whole job
+	Retries        int           // Extra dial attempts after the first
+}
+
+// networkTransport sends jobs over a raw TCP connection
+type networkTransport struct {
+	address string
+	opts    NetworkOptions
+
+	mu      sync.Mutex
+	conn    net.Conn
+	lastErr error
+}
+
+// NewNetwork returns a transport for a network printer at host[:port].
+// The port defaults to 9100.
+func NewNetwork(address string, opts NetworkOptions) Transport {
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, DefaultNetworkPort)
+	}
+	if opts.ConnectTimeout <= 0 {
+		opts.ConnectTimeout = DefaultConnectTimeout
+	}
+	if opts.WriteTimeout <= 0 {
+		opts.WriteTimeout = DefaultWriteTimeout
+	}
+	if opts.Retries < 0 {
+		opts.Retries = 0
+	}
+	return &networkTransport{address: address, opts: opts}
+}
+
+// Open connects to the printer, retrying with a growing pause because
+// network printers often drop the first connection after waking up
+func (t *networkTransport) Open() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.conn != nil {
+		return nil
+	}
+	return t.dial()
+}
+
+func (t *networkTransport) dial() error {
+	var err error
+	for attempt := 0; attempt <= t.opts.Retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Duration(attempt) * 200 * time.Millisecond)
+		}
+
+		var conn net.Conn
+		conn, err = net.DialTimeout("tcp", t.address, t.opts.ConnectTimeout)
+		if err == nil {
+			t.conn = conn
+			t.lastErr = nil
+			return nil
+		}
+	}
+
+	t.lastErr = err
+	return err
+}
+
+// Write sends p within the write timeout. If the connection turns out to
+// be dead before any byte was accepted, it reconnects and tries once more;
+// a partially written job is never resent to avoid duplicate output.
+func (t *networkTransport) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.conn == nil {
+		return 0, errors.New("transport is not open")
+	}
+
+	n, err := t.write(p)
+	if err != nil && n == 0 {
+		t.conn.Close()
+		t.conn = nil
+		if err = t.dial(); err == nil {
+			n, err = t.write(p)
+		}
+	}
+
+	t.lastErr = err
+	return n, err
+}
+
+func (t *networkTransport) write(p []byte) (int, error) {
+	t.conn.SetWriteDeadline(time.Now().Add(t.opts.WriteTimeout))
+	return t.conn.Write(p)
+}
+
+func (t *networkTransport) ReadTimeout(p []byte, timeout time.Duration) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.conn == nil {
+		return 0, errors.New("transport is not open")
+	}
+
+	t.conn.SetReadDeadline(time.Now().Add(timeout))
+	n, err := t.conn.Read(p)
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		err = ErrReadTimeout
+	}
+	return n, err
+}
+
+func (t *networkTransport) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.conn == nil {
+		return nil
+	}
+
+	err := t.conn.Close()
+	t.conn = nil
+	return err
+}
+
+func (t *networkTransport) Status() Status {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	s := Status{Kind: KindNetwork, Address: t.address, Open: t.conn != nil}
+	if t.lastErr != nil {
+		s.LastError = t.lastErr.Error()
+	}
+	return s
+}
+
+// networkOptions converts the JSON config into NetworkOptions
+func networkOptions(cfg Config) NetworkOptions {
+	opts := NetworkOptions{
+		ConnectTimeout: time.Duration(cfg.ConnectTimeout),
+		WriteTimeout:   time.Duration(cfg.WriteTimeout),
+		Retries:        DefaultRetries,
+	}
+	if cfg.Retries != nil {
+		opts.Retries = *cfg.Retries
+	}
+	return opts
+}
+
+// validPort reports whether the port part of a host:port address is a
+// usable TCP port
+func validPort(address string) bool {
+	_, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return true // NewNetwork adds the default port
+	}
+	n, err := strconv.Atoi(port)
+	return err == nil && n > 0 && n < 65536
+}
@@ -0,0 +1,171 @@
+package transport
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// listen starts a TCP listener that reads one connection at a time and
+// sends everything it received on the returned channel
+func listen(t *testing.T) (string, <-chan []byte) {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	received := make(chan []byte, 4)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			data, _ := io.ReadAll(conn)
+			conn.Close()
+			received <- data
+		}
+	}()
+	return ln.Addr().String(), received
+}
+
+func TestNetworkSend(t *testing.T) {
+	addr, received := listen(t)
+	tr := NewNetwork(addr, NetworkOptions{})
+
+	if err := Send(tr, []byte("receipt")); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case got := <-received:
+		if !bytes.Equal(got, []byte("receipt")) {
+			t.Errorf("printer received %q", got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("nothing received")
+	}
+
+	if s := tr.Status(); s.Kind != KindNetwork || s.Address != addr || s.Open {
+		t.Errorf("unexpected status %+v", s)
+	}
+}
+
+func TestNetworkRead(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	// The printer answers the first byte it receives with 0x16
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		buf := make([]byte, 1)
+		if _, err := conn.Read(buf); err == nil {
+			conn.Write([]byte{0x16})
+		}
+		io.Copy(io.Discard, conn)
+	}()
+
+	tr := NewNetwork(ln.Addr().String(), NetworkOptions{})
+	if err := tr.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Close()
+	r := tr.(Reader)
+
+	buf := make([]byte, 1)
+	if _, err := r.ReadTimeout(buf, 50*time.Millisecond); !errors.Is(err, ErrReadTimeout) {
+		t.Errorf("ReadTimeout before the request = %v", err)
+	}
+	tr.Write([]byte{0x10})
+	if n, err := r.ReadTimeout(buf, 2*time.Second); err != nil || n != 1 || buf[0] != 0x16 {
+		t.Errorf("ReadTimeout() = % X, %v", buf[:n], err)
+	}
+}
+
+func TestNetworkDefaultPort(t *testing.T) {
+	tr := NewNetwork("192.168.1.50", NetworkOptions{})
+	if got := tr.Status().Address; got != "192.168.1.50:9100" {
+		t.Errorf("address = %q, want 192.168.1.50:9100", got)
+	}
+}
+
+func TestNetworkRetriesUntilPrinterWakes(t *testing.T) {
+	// Reserve a port, then free it so the first dials are refused
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	go func() {
+		time.Sleep(300 * time.Millisecond)
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			return
+		}
+		defer ln.Close()
+		conn, err := ln.Accept()
+		if err == nil {
+			io.Copy(io.Discard, conn)
+			conn.Close()
+		}
+	}()
+
+	tr := NewNetwork(addr, NetworkOptions{ConnectTimeout: time.Second, Retries: 5})
+	if err := Send(tr, []byte("x")); err != nil {
+		t.Fatalf("Send did not reconnect: %v", err)
+	}
+}
+
+func TestNetworkUnreachable(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	tr := NewNetwork(addr, NetworkOptions{ConnectTimeout: 100 * time.Millisecond, Retries: 0})
+	if err := Send(tr, []byte("x")); err == nil {
+		t.Fatal("Send to closed port succeeded")
+	}
+	if tr.Status().LastError == "" {
+		t.Error("Status().LastError is empty after failed dial")
+	}
+}
+
+func TestNewNetworkConfig(t *testing.T) {
+	var cfg Config
+	err := json.Unmarshal([]byte(`{"type":"tcp","device":"10.0.0.7","connect_timeout":"2s","write_timeout":1500,"retries":0}`), &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tr, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nt := tr.(*networkTransport)
+	if nt.opts.ConnectTimeout != 2*time.Second || nt.opts.WriteTimeout != 1500*time.Millisecond || nt.opts.Retries != 0 {
+		t.Errorf("unexpected options %+v", nt.opts)
+	}
+
+	if _, err := New(Config{Type: KindNetwork, Device: "10.0.0.7:99999"}); err == nil {
+		t.Error("New accepted an out of range port")
+	}
+}
@@ -0,0 +1,268 @@
+package transport
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"go.bug.st/serial"
+)
+
+// Serial line defaults. Bluetooth SPP ignores the baud rate, but USB-serial
+// printers need the one they were configured for (usually 9600 or 115200).
+const (
+	DefaultBaudRate = 9600
+	DefaultDataBits = 8
+)
+
+// ctsChunk is how many bytes are written between CTS checks when RTS/CTS
+// handshaking is enabled. Small enough to fit the input buffer of the
+// cheapest printers.
+const ctsChunk = 256
+
+// ctsTimeout is how long the printer may hold CTS low before the next
+// chunk. It applies to each chunk rather than the whole job, so slow
+// lines can send logos and long label runs as long as the printer keeps
+// taking data.
+var ctsTimeout = 10 * time.Second
+
+// SerialOptions configures the serial line of COM and tty printers
+type SerialOptions struct {
+	BaudRate int     `json:"baud_rate,omitempty"` // Default 9600
+	DataBits int     `json:"data_bits,omitempty"` // 5-8, default 8
+	Parity   string  `json:"parity,omitempty"`    // "none", "odd", "even", "mark" or "space"
+	StopBits float64 `json:"stop_bits,omitempty"` // 1, 1.5 or 2
+	RTSCTS   bool    `json:"rtscts,omitempty"`    // Wait for CTS before sending each chunk
+}
+
+// mode converts the options into a serial.Mode, applying defaults
+func (o SerialOptions) mode() (*serial.Mode, error) {
+	m := &serial.Mode{
+		BaudRate: o.BaudRate,
+		DataBits: o.DataBits,
+	}
+	if m.BaudRate == 0 {
+		m.BaudRate = DefaultBaudRate
+	}
+	if m.DataBits == 0 {
+		m.DataBits = DefaultDataBits
+	}
+	if m.BaudRate < 0 {
+		return nil, fmt.Errorf("invalid baud rate %d", o.BaudRate)
+	}
+	if m.DataBits < 5 || m.DataBits > 8 {
+		return nil, fmt.Errorf("invalid data bits %d (must be 5-8)", o.DataBits)
+	}
+
+	switch o.Parity {
+	case "", "none":
+		m.Parity = serial.NoParity
+	case "odd":
+		m.Parity = serial.OddParity
+	case "even":
+		m.Parity = serial.EvenParity
+	case "mark":
+		m.Parity = serial.MarkParity
+	case "space":
+		m.Parity = serial.SpaceParity
+	default:
+		return nil, fmt.Errorf("invalid parity %q", o.Parity)
+	}
+
+	switch o.StopBits {
+	case 0, 1:
+		m.StopBits = serial.OneStopBit
+	case 1.5:
+		m.StopBits = serial.OnePointFiveStopBits
+	case 2:
+		m.StopBits = serial.TwoStopBits
+	default:
+		return nil, fmt.Errorf("invalid stop bits %v", o.StopBits)
+	}
+
+	if o.RTSCTS {
+		// Raise RTS so the printer knows we are ready to talk
+		m.InitialStatusBits = &serial.ModemOutputBits{RTS: true, DTR: true}
+	}
+	return m, nil
+}
+
+// Validate reports whether the line settings are supported, so a bad
+// setting is caught before any device is known
+func (o SerialOptions) Validate() error {
+	_, err := o.mode()
+	return err
+}
+
+// String describes the line settings, e.g. "9600 8N1"
+func (o SerialOptions) String() string {
+	m, err := o.mode()
+	if err != nil {
+		return "invalid"
+	}
+
+	parity := "N"
+	if o.Parity != "" && o.Parity != "none" {
+		parity = strings.ToUpper(o.Parity[:1])
+	}
+	stop := "1"
+	if o.StopBits == 1.5 || o.StopBits == 2 {
+		stop = fmt.Sprint(o.StopBits)
+	}
+
+	s := fmt.Sprintf("%d %d%s%s", m.BaudRate, m.DataBits, parity, stop)
+	if o.RTSCTS {
+		s += " RTS/CTS"
+	}
+	return s
+}
+
+// serialTransport writes to a serial port through go.bug.st/serial so the
+// line settings are set explicitly instead of being whatever the OS last
+// left them at
+type serialTransport struct {
+	kind    string
+	address string
+	opts    SerialOptions
+
+	mu      sync.Mutex
+	port    serial.Port
+	lastErr error
+}
+
+// NewCOM returns a transport for a Windows serial port such as "COM10"
+func NewCOM(port string, opts SerialOptions) Transport {
+	return &serialTransport{kind: KindCOM, address: port, opts: opts}
+}
+
+// NewTTY returns a transport for a Linux serial device such as
+// "/dev/rfcomm0" or "/dev/ttyUSB0"
+func NewTTY(device string, opts SerialOptions) Transport {
+	return &serialTransport{kind: KindTTY, address: device, opts: opts}
+}
+
+func (t *serialTransport) Open() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.port != nil {
+		return nil
+	}
+
+	mode, err := t.opts.mode()
+	if err == nil {
+		t.port, err = serial.Open(t.address, mode)
+	}
+	t.lastErr = err
+	return err
+}
+
+func (t *serialTransport) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.port == nil {
+		return 0, errors.New("transport is not open")
+	}
+
+	var n int
+	var err error
+	if t.opts.RTSCTS {
+		n, err = t.writeHandshake(p)
+	} else {
+		n, err = t.port.Write(p)
+	}
+
+	// Wait until the bytes have left the UART so Close cannot discard them
+	if err == nil {
+		err = t.port.Drain()
+	}
+
+	t.lastErr = err
+	return n, err
+}
+
+// writeHandshake sends p in chunks, waiting up to ctsTimeout for the
+// printer to assert CTS before each one
+func (t *serialTransport) writeHandshake(p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		if err := t.waitCTS(time.Now().Add(ctsTimeout)); err != nil {
+			return written, err
+		}
+
+		end := min(written+ctsChunk, len(p))
+		n, err := t.port.Write(p[written:end])
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+func (t *serialTransport) waitCTS(deadline time.Time) error {
+	for {
+		bits, err := t.port.GetModemStatusBits()
+		if err != nil {
+			return err
+		}
+		if bits.CTS {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return errors.New("printer did not assert CTS (buffer full or offline)")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func (t *serialTransport) ReadTimeout(p []byte, timeout time.Duration) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.port == nil {
+		return 0, errors.New("transport is not open")
+	}
+	if err := t.port.SetReadTimeout(timeout); err != nil {
+		return 0, err
+	}
+
+	// go.bug.st/serial reports a timeout as a read of 0 bytes
+	n, err := t.port.Read(p)
+	if err == nil && n == 0 {
+		err = ErrReadTimeout
+	}
+	return n, err
+}
+
+func (t *serialTransport) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.port == nil {
+		return nil
+	}
+
+	err := t.port.Close()
+	t.port = nil
+	return err
+}
+
+func (t *serialTransport) Status() Status {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	s := Status{
+		Kind:     t.kind,
+		Address:  t.address,
+		Open:     t.port != nil,
+		Settings: t.opts.String(),
+	}
+	if t.lastErr != nil {
+		s.LastError = t.lastErr.Error()
+	}
+	return s
+}
@@ -0,0 +1,111 @@
+package transport
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+// cbaud masks the baud rate bits of c_cflag (CBAUD, missing from syscall)
+const cbaud = 0o10017
+
+// ioctl runs the ioctl req on fd with a pointer argument
+func ioctl(fd uintptr, req uint, arg unsafe.Pointer) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, uintptr(req), uintptr(arg)); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// openPTY returns the master side of a new pseudo-terminal and the path of
+// its slave, which stands in for a serial printer
+func openPTY(t *testing.T) (*os.File, string) {
+	t.Helper()
+
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		t.Skipf("pseudo-terminals unavailable: %v", err)
+	}
+	t.Cleanup(func() { master.Close() })
+
+	var unlock, n int32
+	if err := ioctl(master.Fd(), syscall.TIOCSPTLCK, unsafe.Pointer(&unlock)); err != nil {
+		t.Fatalf("unlockpt: %v", err)
+	}
+	if err := ioctl(master.Fd(), syscall.TIOCGPTN, unsafe.Pointer(&n)); err != nil {
+		t.Fatalf("ptsname: %v", err)
+	}
+	return master, fmt.Sprintf("/dev/pts/%d", n)
+}
+
+func TestSerialSendPTY(t *testing.T) {
+	master, slave := openPTY(t)
+
+	tr := NewTTY(slave, SerialOptions{BaudRate: 115200})
+	if err := tr.Open(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The line settings must have been applied when the port was opened
+	var termios syscall.Termios
+	if err := ioctl(master.Fd(), syscall.TCGETS, unsafe.Pointer(&termios)); err != nil {
+		t.Fatal(err)
+	}
+	if termios.Ospeed != syscall.B115200 && termios.Cflag&cbaud != syscall.B115200 {
+		t.Errorf("baud rate not applied: cflag=%#o ospeed=%d", termios.Cflag, termios.Ospeed)
+	}
+
+	want := []byte{0x1B, 0x40, 'h', 'i', '\n'}
+	if _, err := tr.Write(want); err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	master.SetReadDeadline(time.Now().Add(2 * time.Second))
+	got := make([]byte, len(want))
+	if _, err := master.Read(got); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("printer received % X, want % X", got, want)
+	}
+
+	if s := tr.Status(); s.Settings != "115200 8N1" || s.Open {
+		t.Errorf("unexpected status %+v", s)
+	}
+}
+
+func TestSerialReadPTY(t *testing.T) {
+	master, slave := openPTY(t)
+
+	tr := NewTTY(slave, SerialOptions{})
+	if err := tr.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Close()
+	r := tr.(Reader)
+
+	buf := make([]byte, 1)
+	if _, err := r.ReadTimeout(buf, 50*time.Millisecond); !errors.Is(err, ErrReadTimeout) {
+		t.Errorf("ReadTimeout with nothing sent = %v", err)
+	}
+
+	master.Write([]byte{0x16})
+	if n, err := r.ReadTimeout(buf, 2*time.Second); err != nil || n != 1 || buf[0] != 0x16 {
+		t.Errorf("ReadTimeout() = % X, %v", buf[:n], err)
+	}
+}
+
+func TestSerialMissingDevice(t *testing.T) {
+	tr := NewTTY("/dev/does-not-exist", SerialOptions{})
+	if err := Send(tr, []byte("x")); err == nil {
+		t.Fatal("Send to missing device succeeded")
+	}
+}
@@ -0,0 +1,155 @@
+// Package transport delivers rendered ESC/POS bytes to a printer.
+//
+// The HTTP layer only ever sees the Transport interface; whether the bytes
+// end up on a Windows COM port, a Linux tty, a plain file or in memory is
+// decided by the Config an agent is started with.
+package transport
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"cleanlink/printer/jsontime"
+)
+
+// Transport kinds
+const (
+	KindCOM     = "com"    // Windows serial port, e.g. COM10
+	KindTTY     = "tty"    // Linux serial device, e.g. /dev/rfcomm0
+	KindFile    = "file"   // Raw file or device node, e.g. /dev/usb/lp0
+	KindNetwork = "tcp"    // Raw TCP network printer, e.g. 192.168.1.50:9100
+	KindMemory  = "memory" // In-memory sink for tests and dry runs
+)
+
+// Transport is a connection to a printer
+type Transport interface {
+	// Open prepares the printer for writing
+	Open() error
+	// Write sends data to the printer
+	Write(p []byte) (int, error)
+	// Close releases the printer
+	Close() error
+	// Status reports what the transport knows about the printer
+	Status() Status
+}
+
+// Reader is implemented by transports that can receive the printer's
+// replies, such as serial and network printers. It is only used while the
+// transport is open.
+type Reader interface {
+	// ReadTimeout reads what the printer sent, waiting at most timeout
+	// for the first byte. It returns ErrReadTimeout if nothing arrived.
+	ReadTimeout(p []byte, timeout time.Duration) (int, error)
+}
+
+// ErrReadTimeout is returned by ReadTimeout when the printer sent nothing
+var ErrReadTimeout = errors.New("printer did not reply")
+
+// Status describes a transport
+type Status struct {
+	Kind      string `json:"kind"`
+	Address   string `json:"address"`
+	Open      bool   `json:"open"`
+	Settings  string `json:"settings,omitempty"` // Serial line settings, e.g. "9600 8N1"
+	LastError string `json:"last_error,omitempty"`
+}
+
+// Config selects and configures a transport
+type Config struct {
+	Type   string `json:"type"`   // "com", "tty", "file", "tcp" or "memory"
+	Device string `json:"device"` // Port name, device node, file path or host[:port]
+
+	// Fingerprint identifies a discovered printer across port changes,
+	// e.g. "bt:00:11:22:AA:BB:CC". Device is re-resolved from it.
+	Fingerprint string `json:"fingerprint,omitempty"`
+
+	// Serial printers only
+	SerialOptions
+
+	// Network printers only
+	ConnectTimeout jsontime.Duration `json:"connect_timeout,omitempty"` // Default 5s
+	WriteTimeout   jsontime.Duration `json:"write_timeout,omitempty"`   // Default 10s
+	Retries        *int              `json:"retries,omitempty"`         // Extra dial attempts, default 3
+}
+
+// New returns the transport described by cfg
+func New(cfg Config) (Transport, error) {
+	switch cfg.Type {
+	case KindCOM:
+		if cfg.Device == "" {
+			return nil, errors.New("com transport requires a device such as COM10")
+		}
+		if _, err := cfg.SerialOptions.mode(); err != nil {
+			return nil, err
+		}
+		return NewCOM(cfg.Device, cfg.SerialOptions), nil
+	case KindTTY:
+		if cfg.Device == "" {
+			return nil, errors.New("tty transport requires a device such as /dev/rfcomm0")
+		}
+		if _, err := cfg.SerialOptions.mode(); err != nil {
+			return nil, err
+		}
+		return NewTTY(cfg.Device, cfg.SerialOptions), nil
+	case KindFile:
+		if cfg.Device == "" {
+			return nil, errors.New("file transport requires a path")
+		}
+		return NewFile(cfg.Device), nil
+	case KindNetwork:
+		if cfg.Device == "" {
+			return nil, errors.New("tcp transport requires a host such as 192.168.1.50:9100")
+		}
+		if !validPort(cfg.Device) {
+			return nil, fmt.Errorf("invalid port in %q", cfg.Device)
+		}
+		return NewNetwork(cfg.Device, networkOptions(cfg)), nil
+	case KindMemory:
+		return NewMemory(), nil
+	default:
+		return nil, fmt.Errorf("unknown transport type %q", cfg.Type)
+	}
+}
+
+// OpError reports which step of Send failed. Its message is the message
+// of the underlying error.
+type OpError struct {
+	Op  string // "open", "check", "write" or "close"
+	Err error
+}
+
+func (e *OpError) Error() string { return e.Err.Error() }
+
+func (e *OpError) Unwrap() error { return e.Err }
+
+// Send opens t, writes all of data in a single operation and closes it
+// again. Writing everything at once keeps the ESC/POS commands together
+// without buffering delays between them.
+func Send(t Transport, data []byte) error {
+	return SendChecked(t, data, nil)
+}
+
+// SendChecked is Send with a check that runs after t is opened, e.g. a
+// printer status query. If check fails nothing is written.
+func SendChecked(t Transport, data []byte, check func() error) error {
+	if err := t.Open(); err != nil {
+		return &OpError{Op: "open", Err: err}
+	}
+
+	var err error
+	if check != nil {
+		if err = check(); err != nil {
+			err = &OpError{Op: "check", Err: err}
+		}
+	}
+	if err == nil {
+		if _, err = t.Write(data); err != nil {
+			err = &OpError{Op: "write", Err: err}
+		}
+	}
+	if cerr := t.Close(); err == nil && cerr != nil {
+		err = &OpError{Op: "close", Err: cerr}
+	}
+	return err
+}
@@ -0,0 +1,231 @@
+package transport
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.bug.st/serial"
+)
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		cfg     Config
+		kind    string
+		wantErr bool
+	}{
+		{Config{Type: KindCOM, Device: "COM10"}, KindCOM, false},
+		{Config{Type: KindTTY, Device: "/dev/rfcomm0"}, KindTTY, false},
+		{Config{Type: KindFile, Device: "out.bin"}, KindFile, false},
+		{Config{Type: KindMemory}, KindMemory, false},
+		{Config{Type: KindCOM}, "", true},
+		{Config{Type: "carrier-pigeon"}, "", true},
+	}
+
+	for _, tt := range tests {
+		tr, err := New(tt.cfg)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("New(%+v) succeeded, want error", tt.cfg)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("New(%+v): %v", tt.cfg, err)
+			continue
+		}
+		if got := tr.Status().Kind; got != tt.kind {
+			t.Errorf("New(%+v) kind = %q, want %q", tt.cfg, got, tt.kind)
+		}
+	}
+}
+
+func TestCOMAddress(t *testing.T) {
+	tr := NewCOM("COM10", SerialOptions{})
+	if got := tr.Status().Address; got != "COM10" {
+		t.Errorf("address = %q, want COM10", got)
+	}
+}
+
+func TestSendFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "printer.bin")
+	tr := NewFile(path)
+
+	if err := Send(tr, []byte("first")); err != nil {
+		t.Fatal(err)
+	}
+	if err := Send(tr, []byte("second")); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "firstsecond" {
+		t.Errorf("file = %q, want %q", got, "firstsecond")
+	}
+	if tr.Status().Open {
+		t.Error("transport still open after Send")
+	}
+}
+
+func TestSendOpenError(t *testing.T) {
+	tr := NewFile(filepath.Join(t.TempDir(), "missing", "printer.bin"))
+
+	if err := Send(tr, []byte("x")); err == nil {
+		t.Fatal("Send to missing device succeeded")
+	}
+	if tr.Status().LastError == "" {
+		t.Error("Status().LastError is empty after failed open")
+	}
+}
+
+func TestMemory(t *testing.T) {
+	m := NewMemory()
+
+	if _, err := m.Write([]byte("x")); err == nil {
+		t.Error("Write before Open succeeded")
+	}
+
+	if err := Send(m, []byte("abc")); err != nil {
+		t.Fatal(err)
+	}
+	if err := Send(m, []byte("def")); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := m.Bytes(); !bytes.Equal(got, []byte("abcdef")) {
+		t.Errorf("Bytes() = %q", got)
+	}
+	if n := len(m.Writes()); n != 2 {
+		t.Errorf("len(Writes()) = %d, want 2", n)
+	}
+
+	m.OpenErr = errors.New("offline")
+	if err := Send(m, []byte("x")); err == nil {
+		t.Error("Send succeeded with OpenErr set")
+	}
+}
+
+func TestSerialOptions(t *testing.T) {
+	tests := []struct {
+		opts    SerialOptions
+		want    string
+		wantErr bool
+	}{
+		{SerialOptions{}, "9600 8N1", false},
+		{SerialOptions{BaudRate: 115200, Parity: "even", StopBits: 2, RTSCTS: true}, "115200 8E2 RTS/CTS", false},
+		{SerialOptions{DataBits: 7, Parity: "odd", StopBits: 1.5}, "9600 7O1.5", false},
+		{SerialOptions{Parity: "sideways"}, "", true},
+		{SerialOptions{StopBits: 3}, "", true},
+		{SerialOptions{DataBits: 9}, "", true},
+	}
+
+	for _, tt := range tests {
+		_, err := New(Config{Type: KindTTY, Device: "/dev/ttyUSB0", SerialOptions: tt.opts})
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("New accepted %+v", tt.opts)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("New(%+v): %v", tt.opts, err)
+		}
+		if got := tt.opts.String(); got != tt.want {
+			t.Errorf("%+v.String() = %q, want %q", tt.opts, got, tt.want)
+		}
+	}
+}
+
+func TestSendChecked(t *testing.T) {
+	m := NewMemory()
+
+	err := SendChecked(m, []byte("abc"), func() error { return errors.New("paper out") })
+	var op *OpError
+	if !errors.As(err, &op) || op.Op != "check" {
+		t.Fatalf("SendChecked() = %v, want a check error", err)
+	}
+	if len(m.Bytes()) != 0 {
+		t.Errorf("job written after failed check: %q", m.Bytes())
+	}
+	if m.Status().Open {
+		t.Error("transport left open")
+	}
+}
+
+func TestReplier(t *testing.T) {
+	r := NewReplier(bytes.ToUpper)
+	r.Open()
+	defer r.Close()
+
+	r.Write([]byte("ok"))
+	buf := make([]byte, 4)
+	if n, err := r.ReadTimeout(buf, time.Second); err != nil || string(buf[:n]) != "OK" {
+		t.Errorf("ReadTimeout() = %q, %v", buf[:n], err)
+	}
+	if _, err := r.ReadTimeout(buf, 10*time.Millisecond); !errors.Is(err, ErrReadTimeout) {
+		t.Errorf("ReadTimeout with nothing sent = %v", err)
+	}
+	if got := r.Bytes(); string(got) != "ok" {
+		t.Errorf("Bytes() = %q", got)
+	}
+
+	// A read waits for bytes the printer sends on its own
+	time.AfterFunc(10*time.Millisecond, func() { r.Push([]byte{0x10}) })
+	if n, err := r.ReadTimeout(buf, time.Second); err != nil || n != 1 || buf[0] != 0x10 {
+		t.Errorf("ReadTimeout() after Push = % X, %v", buf[:n], err)
+	}
+}
+
+// busyPort is a serial port whose printer holds CTS low for busy after
+// every chunk it receives, like a printer feeding paper
+type busyPort struct {
+	serial.Port
+	busy  time.Duration
+	until time.Time
+	got   []byte
+}
+
+func (p *busyPort) Write(b []byte) (int, error) {
+	p.got = append(p.got, b...)
+	p.until = time.Now().Add(p.busy)
+	return len(b), nil
+}
+
+func (p *busyPort) GetModemStatusBits() (*serial.ModemStatusBits, error) {
+	return &serial.ModemStatusBits{CTS: time.Now().After(p.until)}, nil
+}
+
+func (p *busyPort) Drain() error { return nil }
+
+func TestSerialHandshake(t *testing.T) {
+	defer func(d time.Duration) { ctsTimeout = d }(ctsTimeout)
+	ctsTimeout = 50 * time.Millisecond
+
+	// The whole job takes longer than ctsTimeout, but no single chunk does
+	port := &busyPort{busy: 20 * time.Millisecond}
+	tr := &serialTransport{kind: KindCOM, address: "COM10", opts: SerialOptions{RTSCTS: true}, port: port}
+	job := bytes.Repeat([]byte("x"), 8*ctsChunk)
+	if n, err := tr.Write(job); err != nil || n != len(job) {
+		t.Fatalf("Write = %d, %v", n, err)
+	}
+	if !bytes.Equal(port.got, job) {
+		t.Errorf("printer got %d bytes, want %d", len(port.got), len(job))
+	}
+
+	// A printer that stops taking data fails the job
+	port = &busyPort{busy: time.Second}
+	tr.port = port
+	n, err := tr.Write(job)
+	if err == nil {
+		t.Fatal("Write to a stalled printer succeeded")
+	}
+	if n != ctsChunk {
+		t.Errorf("wrote %d bytes before the stall, want %d", n, ctsChunk)
+	}
+}
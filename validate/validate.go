@@ -0,0 +1,109 @@
+// Package validate checks print requests before they are queued, so a bad
+// request is rejected with every problem listed instead of printing a
+// blank header or a QR code the printer cannot fit.
+package validate
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"cleanlink/printer/escpos"
+	"cleanlink/printer/render"
+)
+
+// Limits on request fields, in characters
+const (
+	MaxTitle       = 64
+	MaxOrderID     = 64
+	MaxBody        = 4096
+	MaxServiceName = 64
+	MaxLabelBody   = 1024
+	MaxQRCodes     = 20
+)
+
+// MaxRequestBytes is the largest request body the agent reads
+const MaxRequestBytes = 64 << 10
+
+// FieldError describes one invalid field
+type FieldError struct {
+	Field   string `json:"field"` // JSON path, e.g. "qr_codes[1].qr_value"
+	Message string `json:"message"`
+}
+
+// Errors lists every invalid field of a request
+type Errors []FieldError
+
+func (e Errors) Error() string {
+	msgs := make([]string, len(e))
+	for i, fe := range e {
+		msgs[i] = fe.Field + ": " + fe.Message
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (e *Errors) add(field, format string, args ...any) {
+	*e = append(*e, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+func (e *Errors) maxLen(field, value string, max int) {
+	if n := utf8.RuneCountInString(value); n > max {
+		e.add(field, "is %d characters, the maximum is %d", n, max)
+	}
+}
+
+// PrintRequest checks req against the receipt layout it will be printed
+// with. It returns nil if req is valid.
+func PrintRequest(req render.PrintRequest, layout render.Options) error {
+	var errs Errors
+
+	mode := req.Mode()
+	if !render.ValidMode(mode) {
+		errs.add("print_mode", "unknown mode %q (use all, receipt-only, qr-only, label or separator)", req.PrintMode)
+	}
+
+	printsTitle := mode == render.ModeAll || mode == render.ModeReceiptOnly || mode == render.ModeLabel
+	if printsTitle && strings.TrimSpace(req.Title) == "" {
+		errs.add("title", "is required for %s", mode)
+	}
+	errs.maxLen("title", req.Title, MaxTitle)
+	errs.maxLen("order_id", req.OrderID, MaxOrderID)
+	errs.maxLen("body", req.Body, MaxBody)
+
+	needsLabels := mode == render.ModeQROnly || mode == render.ModeLabel
+	if needsLabels && len(req.QRCodes) == 0 && req.QRValue == "" {
+		errs.add("qr_codes", "at least one QR code is required for %s", mode)
+	}
+	if len(req.QRCodes) > MaxQRCodes {
+		errs.add("qr_codes", "has %d entries, the maximum is %d", len(req.QRCodes), MaxQRCodes)
+	}
+
+	maxQR := layout.QR.MaxBytes(layout.Width * escpos.DotsPerColumn)
+	checkQR := func(field, value string) {
+		if value == "" {
+			errs.add(field, "is required")
+		} else if len(value) > maxQR {
+			errs.add(field, "is %d bytes, a QR code at size %d with this error correction fits %d on this paper", len(value), layout.QR.Size, maxQR)
+		}
+	}
+
+	if req.QRValue != "" {
+		checkQR("qr_value", req.QRValue)
+	}
+	for i, qr := range req.QRCodes {
+		field := fmt.Sprintf("qr_codes[%d].", i)
+		// Label mode heads every label with the title instead
+		if mode != render.ModeLabel && strings.TrimSpace(qr.ServiceName) == "" {
+			errs.add(field+"service_name", "is required")
+		}
+		errs.maxLen(field+"service_name", qr.ServiceName, MaxServiceName)
+		errs.maxLen(field+"order_id", qr.OrderID, MaxOrderID)
+		errs.maxLen(field+"body", qr.Body, MaxLabelBody)
+		checkQR(field+"qr_value", qr.QRValue)
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
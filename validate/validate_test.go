@@ -0,0 +1,97 @@
+package validate
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"cleanlink/printer/render"
+)
+
+func fields(err error) []string {
+	var errs Errors
+	if !errors.As(err, &errs) {
+		return nil
+	}
+	var out []string
+	for _, fe := range errs {
+		out = append(out, fe.Field)
+	}
+	return out
+}
+
+func TestValidRequest(t *testing.T) {
+	req := render.PrintRequest{
+		Title:   "Smart Laundry",
+		OrderID: "ORD-12345",
+		Body:    "Nama : Bu Kayam\n",
+		QRCodes: []render.QRCodeData{
+			{ServiceName: "Cuci + Setrika", OrderID: "ORD-12345-1", QRValue: "https://cleanlink.com/track/ORD-12345-1"},
+		},
+	}
+	if err := PrintRequest(req, render.DefaultOptions); err != nil {
+		t.Errorf("valid request rejected: %v", err)
+	}
+
+	separator := render.PrintRequest{PrintMode: render.ModeSeparator}
+	if err := PrintRequest(separator, render.DefaultOptions); err != nil {
+		t.Errorf("separator rejected: %v", err)
+	}
+}
+
+func TestAllErrorsReported(t *testing.T) {
+	req := render.PrintRequest{
+		PrintMode: render.ModeAll,
+		OrderID:   strings.Repeat("x", MaxOrderID+1),
+		Body:      strings.Repeat("x", MaxBody+1),
+		QRCodes: []render.QRCodeData{
+			{ServiceName: "Cuci", QRValue: strings.Repeat("x", 181)},
+			{},
+		},
+	}
+
+	got := strings.Join(fields(PrintRequest(req, render.DefaultOptions)), ",")
+	want := "title,order_id,body,qr_codes[0].qr_value,qr_codes[1].service_name,qr_codes[1].qr_value"
+	if got != want {
+		t.Errorf("fields = %s\nwant     %s", got, want)
+	}
+}
+
+func TestUnknownMode(t *testing.T) {
+	err := PrintRequest(render.PrintRequest{Title: "x", PrintMode: "poster"}, render.DefaultOptions)
+	if got := fields(err); len(got) != 1 || got[0] != "print_mode" {
+		t.Errorf("fields = %v", got)
+	}
+}
+
+func TestLabelModes(t *testing.T) {
+	err := PrintRequest(render.PrintRequest{PrintMode: render.ModeQROnly}, render.DefaultOptions)
+	if got := fields(err); len(got) != 1 || got[0] != "qr_codes" {
+		t.Errorf("qr-only without codes: fields = %v", got)
+	}
+
+	// Label mode heads labels with the title, so service names are optional
+	label := render.PrintRequest{
+		Title:     "Smart Laundry",
+		PrintMode: render.ModeLabel,
+		QRCodes:   []render.QRCodeData{{QRValue: "ORD-1"}},
+	}
+	if err := PrintRequest(label, render.DefaultOptions); err != nil {
+		t.Errorf("label rejected: %v", err)
+	}
+}
+
+func TestQRCapacityDependsOnPaper(t *testing.T) {
+	// 200 bytes does not fit at size 7 on 57mm paper (version 9, 180 bytes)
+	// but does on 80mm paper (version 15, 412 bytes)
+	req := render.PrintRequest{Title: "x", QRValue: strings.Repeat("x", 200)}
+
+	if err := PrintRequest(req, render.DefaultOptions); err == nil {
+		t.Error("oversized QR accepted on 57mm paper")
+	}
+	wide := render.DefaultOptions
+	wide.Width = 48
+	if err := PrintRequest(req, wide); err != nil {
+		t.Errorf("QR rejected on 80mm paper: %v", err)
+	}
+}